@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const versionURL = "/version"
+
+// Build metadata injected at build time, i.e.:
+//
+//	go build -ldflags "-X github.com/teran/go-collection/applications/metrics.Version=v1.0.0"
+//
+// Values missing are populated from runtime/debug.ReadBuildInfo if possible.
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+type Dependency struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+type BuildInfo struct {
+	Version      string       `json:"version"`
+	Commit       string       `json:"commit"`
+	BuildTime    string       `json:"build_time"`
+	GoVersion    string       `json:"go_version"`
+	Path         string       `json:"path"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// ReadBuildInfo returns build metadata of the running binary preferring
+// the values injected with ldflags
+func ReadBuildInfo() BuildInfo {
+	bi := BuildInfo{
+		Version:      Version,
+		Commit:       Commit,
+		BuildTime:    BuildTime,
+		GoVersion:    runtime.Version(),
+		Dependencies: []Dependency{},
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+
+	bi.Path = info.Path
+	if bi.Version == "" && info.Main.Version != "(devel)" {
+		bi.Version = info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && bi.Commit == "":
+			bi.Commit = s.Value
+		case s.Key == "vcs.time" && bi.BuildTime == "":
+			bi.BuildTime = s.Value
+		}
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		bi.Dependencies = append(bi.Dependencies, Dependency{
+			Path:    dep.Path,
+			Version: dep.Version,
+			Sum:     dep.Sum,
+		})
+	}
+
+	return bi
+}
+
+// registerBuildInfo exposes build_info gauge the same way go_build_info is
+// exposed by the Go collector
+func registerBuildInfo(registerer prometheus.Registerer) {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Build information of the application, the value is always 1",
+	}, []string{"version", "commit", "build_time", "go_version"})
+
+	g, err := register(registerer, g)
+	if err != nil {
+		panic(err)
+	}
+
+	bi := ReadBuildInfo()
+	g.WithLabelValues(bi.Version, bi.Commit, bi.BuildTime, bi.GoVersion).Set(1)
+}
+
+func buildInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, ReadBuildInfo())
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfo(t *testing.T) {
+	r := require.New(t)
+
+	defer func(version, commit, buildTime string) {
+		Version, Commit, BuildTime = version, commit, buildTime
+	}(Version, Commit, BuildTime)
+
+	Version, Commit, BuildTime = "v1.2.3", "deadbeef", "2026-01-01T00:00:00Z"
+
+	e := echo.New()
+	New(nil, nil, nil, WithRegisterer(prometheus.NewRegistry())).Register(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	code, v, err := get(context.TODO(), srv.URL+versionURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal("v1.2.3", v["version"])
+	r.Equal("deadbeef", v["commit"])
+	r.Equal("2026-01-01T00:00:00Z", v["build_time"])
+	r.Equal(runtime.Version(), v["go_version"])
+	r.NotEmpty(v["dependencies"])
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, `build_info{build_time="2026-01-01T00:00:00Z",commit="deadbeef",go_version="`+runtime.Version()+`",version="v1.2.3"} 1`)
+}
+
+func TestBuildInfoRegisteredWithEndpoints(t *testing.T) {
+	r := require.New(t)
+
+	reg := prometheus.NewRegistry()
+
+	// New has no side effects on the registry
+	m := New(nil, nil, nil, WithRegisterer(reg))
+	New(nil, nil, nil, WithRegisterer(reg))
+
+	mfs, err := reg.Gather()
+	r.NoError(err)
+	r.Empty(mfs)
+
+	m.RegisterMux(http.NewServeMux())
+	m.Register(echo.New())
+	New(nil, nil, nil, WithRegisterer(reg)).Handler()
+
+	mfs, err = reg.Gather()
+	r.NoError(err)
+
+	idx := slices.IndexFunc(mfs, func(mf *dto.MetricFamily) bool {
+		return mf.GetName() == "build_info"
+	})
+	r.GreaterOrEqual(idx, 0)
+	r.Len(mfs[idx].GetMetric(), 1)
+}
+
+func TestReadBuildInfoDefaults(t *testing.T) {
+	r := require.New(t)
+
+	bi := ReadBuildInfo()
+	r.Equal(runtime.Version(), bi.GoVersion)
+	r.Equal(Version, bi.Version)
+	r.NotNil(bi.Dependencies)
+}
@@ -0,0 +1,524 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultCheckTimeout = 5 * time.Second
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	StatusStale  = "stale"
+)
+
+var (
+	_ HealthRegistry = (*healthRegistry)(nil)
+
+	ErrDuplicateCheck = errors.New("check is already registered")
+	ErrUnknownCheck   = errors.New("check is not registered")
+	ErrNoChecks       = errors.New("not implemented: no checks registered")
+	ErrNoResult       = errors.New("check was not run yet")
+	ErrStaleResult    = errors.New("check result is stale")
+)
+
+// Probe is the kind of Kubernetes probe the check is used for
+type Probe int
+
+const (
+	Liveness Probe = iota
+	Readiness
+	Startup
+)
+
+func (p Probe) String() string {
+	switch p {
+	case Liveness:
+		return "liveness"
+	case Readiness:
+		return "readiness"
+	case Startup:
+		return "startup"
+	default:
+		return "unknown(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// CheckFunc checks the component health. It's expected to respect
+// the context deadline, the check is considered failed once it's exceeded
+// anyway.
+type CheckFunc func(ctx context.Context) error
+
+// FromFunc adapts probe functions accepted by New to CheckFunc
+func FromFunc(fn func() error) CheckFunc {
+	return func(context.Context) error {
+		return fn()
+	}
+}
+
+type CheckOption func(c *registeredCheck)
+
+// WithTimeout sets the check timeout, 5s by default
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.timeout = timeout
+	}
+}
+
+// NonCritical makes the check failure reported in the response without
+// failing the probe
+func NonCritical() CheckOption {
+	return func(c *registeredCheck) {
+		c.critical = false
+	}
+}
+
+// WithBackground runs the check in the background each interval once
+// HealthRegistry.Run is called. Probes serve the last result along with
+// its age instead of running the check.
+func WithBackground(interval time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.interval = interval
+	}
+}
+
+// WithTTL marks the background check result as stale and failed once it's
+// older than ttl
+func WithTTL(ttl time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.ttl = ttl
+	}
+}
+
+// WithFailureThreshold reports the check as failed only after n consecutive
+// failures to avoid flapping
+func WithFailureThreshold(n int) CheckOption {
+	return func(c *registeredCheck) {
+		c.failureThreshold = n
+	}
+}
+
+// CheckFilter selects checks run by HealthRegistry.Check by name
+type CheckFilter func(name string) bool
+
+// Only runs the checks with the names given only
+func Only(names ...string) CheckFilter {
+	return func(name string) bool {
+		return slices.Contains(names, name)
+	}
+}
+
+// Exclude skips the checks with the names given
+func Exclude(names ...string) CheckFilter {
+	return func(name string) bool {
+		return !slices.Contains(names, name)
+	}
+}
+
+// CheckResult is the result of the single check run. Age is set for
+// background checks only.
+type CheckResult struct {
+	Name                string
+	Status              string
+	Critical            bool
+	Duration            time.Duration
+	Age                 time.Duration
+	ConsecutiveFailures int
+	Error               error
+}
+
+func (r CheckResult) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Name                string `json:"name"`
+		Status              string `json:"status"`
+		Critical            bool   `json:"critical"`
+		Duration            string `json:"duration"`
+		Age                 string `json:"age,omitempty"`
+		ConsecutiveFailures int    `json:"consecutive_failures,omitempty"`
+		Error               string `json:"error,omitempty"`
+	}{
+		Name:                r.Name,
+		Status:              r.Status,
+		Critical:            r.Critical,
+		Duration:            r.Duration.String(),
+		ConsecutiveFailures: r.ConsecutiveFailures,
+	}
+
+	if r.Age > 0 {
+		v.Age = r.Age.String()
+	}
+
+	if r.Error != nil {
+		v.Error = r.Error.Error()
+	}
+
+	return json.Marshal(v)
+}
+
+// ProbeResult is the result of all the checks of the probe. Status is
+// failed if any critical check failed.
+type ProbeResult struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Err returns the error of the first failed critical check if any
+func (r ProbeResult) Err() error {
+	for _, c := range r.Checks {
+		if c.Critical && c.Error != nil {
+			return errors.Wrapf(c.Error, "check `%s` failed", c.Name)
+		}
+	}
+	return nil
+}
+
+// HealthRegistry keeps named checks of the components per probe
+type HealthRegistry interface {
+	Register(probe Probe, name string, fn CheckFunc, opts ...CheckOption) error
+	// Check runs the checks of the probe matching all the filters
+	// concurrently
+	Check(ctx context.Context, probe Probe, filters ...CheckFilter) (ProbeResult, error)
+	// ProbeFunc returns the function compatible with New
+	ProbeFunc(probe Probe) func() error
+	// Names returns names of the checks registered for the probe
+	Names(probe Probe) []string
+	// Run runs background checks until the context is done
+	Run(ctx context.Context) error
+}
+
+type registeredCheck struct {
+	probe            Probe
+	name             string
+	fn               CheckFunc
+	timeout          time.Duration
+	critical         bool
+	interval         time.Duration
+	ttl              time.Duration
+	failureThreshold int
+	registry         *healthRegistry
+
+	mutex    sync.Mutex
+	last     CheckResult
+	lastAt   time.Time
+	failures int
+}
+
+type healthRegistry struct {
+	// metrics are created on the first use so NewWithHealthRegistry is able
+	// to switch them to the registerer of AppMetrics
+	metricsMutex  sync.Mutex
+	registerer    prometheus.Registerer
+	registererSet bool
+	metrics       *healthMetrics
+
+	mutex  sync.RWMutex
+	checks map[Probe][]*registeredCheck
+	runCtx context.Context
+	wg     sync.WaitGroup
+}
+
+type HealthRegistryOption func(r *healthRegistry)
+
+// WithHealthRegistryRegisterer sets the registerer for check metrics. The
+// registerer set with WithRegisterer is used by default once the registry
+// is passed to NewWithHealthRegistry, prometheus.DefaultRegisterer otherwise.
+func WithHealthRegistryRegisterer(registerer prometheus.Registerer) HealthRegistryOption {
+	return func(r *healthRegistry) {
+		r.registerer = registerer
+		r.registererSet = true
+	}
+}
+
+func NewHealthRegistry(opts ...HealthRegistryOption) HealthRegistry {
+	r := &healthRegistry{
+		registerer: prometheus.DefaultRegisterer,
+		checks:     map[Probe][]*registeredCheck{},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *healthRegistry) healthMetrics() *healthMetrics {
+	r.metricsMutex.Lock()
+	defer r.metricsMutex.Unlock()
+
+	if r.metrics == nil {
+		r.metrics = newHealthMetrics(r.registerer)
+	}
+	return r.metrics
+}
+
+// useRegisterer switches check metrics to the registerer of AppMetrics,
+// it panics if the different registerer is set explicitly
+func (r *healthRegistry) useRegisterer(registerer prometheus.Registerer) {
+	r.metricsMutex.Lock()
+	defer r.metricsMutex.Unlock()
+
+	if r.registerer == registerer {
+		return
+	}
+
+	if r.registererSet {
+		panic(errors.New("health registry registerer differs from the one set with WithRegisterer"))
+	}
+
+	r.registerer = registerer
+	r.metrics = nil
+}
+
+func (r *healthRegistry) Register(probe Probe, name string, fn CheckFunc, opts ...CheckOption) error {
+	c := &registeredCheck{
+		probe:            probe,
+		name:             name,
+		fn:               fn,
+		timeout:          defaultCheckTimeout,
+		critical:         true,
+		failureThreshold: 1,
+		registry:         r,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.checks[probe] {
+		if existing.name == name {
+			return errors.Wrapf(ErrDuplicateCheck, "%s check `%s`", probe, name)
+		}
+	}
+
+	r.checks[probe] = append(r.checks[probe], c)
+	sort.Slice(r.checks[probe], func(i, j int) bool {
+		return r.checks[probe][i].name < r.checks[probe][j].name
+	})
+
+	if r.runCtx != nil && c.interval > 0 {
+		r.wg.Add(1)
+		go r.loop(r.runCtx, c)
+	}
+
+	return nil
+}
+
+// Check returns ErrNoChecks if there are no checks registered for the probe,
+// checks filtered out are not reported in the result
+func (r *healthRegistry) Check(ctx context.Context, probe Probe, filters ...CheckFilter) (ProbeResult, error) {
+	r.mutex.RLock()
+	registered := r.checks[probe]
+	checks := make([]*registeredCheck, 0, len(registered))
+	for _, c := range registered {
+		if matchFilters(c.name, filters) {
+			checks = append(checks, c)
+		}
+	}
+	r.mutex.RUnlock()
+
+	if len(registered) == 0 {
+		return ProbeResult{}, ErrNoChecks
+	}
+
+	results := make([]CheckResult, len(checks))
+
+	wg := &sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if c.interval > 0 {
+				results[i] = c.cached()
+				return
+			}
+			results[i] = c.evaluate(ctx)
+		}()
+	}
+	wg.Wait()
+
+	return newProbeResult(results), nil
+}
+
+func (r *healthRegistry) Names(probe Probe) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.checks[probe]))
+	for _, c := range r.checks[probe] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func (r *healthRegistry) ProbeFunc(probe Probe) func() error {
+	return func() error {
+		result, err := r.Check(context.Background(), probe)
+		if err != nil {
+			return err
+		}
+		return result.Err()
+	}
+}
+
+func (r *healthRegistry) Run(ctx context.Context) error {
+	r.mutex.Lock()
+	r.runCtx = ctx
+	for _, checks := range r.checks {
+		for _, c := range checks {
+			if c.interval > 0 {
+				r.wg.Add(1)
+				go r.loop(ctx, c)
+			}
+		}
+	}
+	r.mutex.Unlock()
+
+	<-ctx.Done()
+
+	r.mutex.Lock()
+	r.runCtx = nil
+	r.mutex.Unlock()
+
+	r.wg.Wait()
+	return nil
+}
+
+func (r *healthRegistry) loop(ctx context.Context, c *registeredCheck) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.evaluate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluate runs the check and stores the result applying the failure
+// threshold
+func (c *registeredCheck) evaluate(ctx context.Context) CheckResult {
+	result := c.run(ctx)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if result.Error != nil {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+	result.ConsecutiveFailures = c.failures
+
+	if result.Error != nil && c.failures < c.failureThreshold {
+		result.Status = StatusOK
+		result.Error = nil
+	}
+
+	c.last = result
+	c.lastAt = time.Now()
+	c.registry.healthMetrics().setStatus(c.probe, c.name, result.Error == nil)
+
+	return result
+}
+
+// cached returns the last result of the background check
+func (c *registeredCheck) cached() CheckResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lastAt.IsZero() {
+		return CheckResult{
+			Name:     c.name,
+			Status:   StatusFailed,
+			Critical: c.critical,
+			Error:    ErrNoResult,
+		}
+	}
+
+	result := c.last
+	result.Age = time.Since(c.lastAt)
+
+	if c.ttl > 0 && result.Age > c.ttl {
+		result.Status = StatusStale
+		result.Error = errors.Wrapf(ErrStaleResult, "last run %s ago", result.Age.Round(time.Millisecond))
+		c.registry.healthMetrics().setStatus(c.probe, c.name, false)
+	}
+	return result
+}
+
+func (c *registeredCheck) run(ctx context.Context) CheckResult {
+	ctx, cancelFn := context.WithTimeout(ctx, c.timeout)
+	defer cancelFn()
+
+	start := time.Now()
+
+	// buffered so the check ignoring the context doesn't leak forever
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "check timed out")
+	}
+
+	result := CheckResult{
+		Name:     c.name,
+		Status:   StatusOK,
+		Critical: c.critical,
+		Duration: time.Since(start),
+		Error:    err,
+	}
+	c.registry.healthMetrics().observeRun(c.probe, c.name, result.Duration, err)
+
+	if err != nil {
+		result.Status = StatusFailed
+	}
+	return result
+}
+
+func matchFilters(name string, filters []CheckFilter) bool {
+	for _, f := range filters {
+		if !f(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func newProbeResult(checks []CheckResult) ProbeResult {
+	result := ProbeResult{
+		Status: StatusOK,
+		Checks: checks,
+	}
+
+	for _, c := range checks {
+		if c.Critical && c.Error != nil {
+			result.Status = StatusFailed
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultCheckName is the check label value for probe functions passed to New
+const defaultCheckName = "default"
+
+type healthMetrics struct {
+	status   *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+// newHealthMetrics panics if the metrics couldn't be registered the same way
+// prometheus.MustRegister does
+func newHealthMetrics(registerer prometheus.Registerer) *healthMetrics {
+	m := &healthMetrics{
+		status: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "health_check_status",
+			Help: "Last reported status of the health check: 1 for ok, 0 for failed",
+		}, []string{"probe", "check"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "health_check_duration_seconds",
+			Help:    "Duration of the health check runs",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"probe", "check"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "health_check_failures_total",
+			Help: "Total amount of the health check failures",
+		}, []string{"probe", "check"}),
+	}
+
+	var err error
+	if m.status, err = register(registerer, m.status); err != nil {
+		panic(err)
+	}
+
+	if m.duration, err = register(registerer, m.duration); err != nil {
+		panic(err)
+	}
+
+	if m.failures, err = register(registerer, m.failures); err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// observeRun records the check run regardless of the reported status
+func (m *healthMetrics) observeRun(probe Probe, check string, d time.Duration, err error) {
+	m.duration.WithLabelValues(probe.String(), check).Observe(d.Seconds())
+	if err != nil {
+		m.failures.WithLabelValues(probe.String(), check).Inc()
+	}
+}
+
+func (m *healthMetrics) setStatus(probe Probe, check string, ok bool) {
+	v := 0.0
+	if ok {
+		v = 1
+	}
+	m.status.WithLabelValues(probe.String(), check).Set(v)
+}
+
+// register registers the collector or reuses already registered one so
+// multiple instances could share the same registerer
+func register[T prometheus.Collector](registerer prometheus.Registerer, c T) (T, error) {
+	if err := registerer.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return c, errors.Wrap(err, "error registering metric")
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthRegistryMetrics(t *testing.T) {
+	r := require.New(t)
+
+	promRegistry := prometheus.NewRegistry()
+
+	// check metrics are recorded with the registerer of AppMetrics
+	reg := NewHealthRegistry()
+	e := echo.New()
+	NewWithHealthRegistry(reg, WithRegisterer(promRegistry)).Register(e)
+
+	r.NoError(reg.Register(Readiness, "metrics-ok", FromFunc(func() error { return nil })))
+	r.NoError(reg.Register(Readiness, "metrics-failing", FromFunc(func() error {
+		return errors.New("blah")
+	}), WithFailureThreshold(2)))
+
+	m := reg.(*healthRegistry).healthMetrics()
+
+	_, err := reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(float64(1), testutil.ToFloat64(m.status.WithLabelValues("readiness", "metrics-ok")))
+	r.Equal(float64(1), testutil.ToFloat64(m.status.WithLabelValues("readiness", "metrics-failing")))
+	r.Equal(float64(1), testutil.ToFloat64(m.failures.WithLabelValues("readiness", "metrics-failing")))
+
+	_, err = reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(float64(1), testutil.ToFloat64(m.status.WithLabelValues("readiness", "metrics-ok")))
+	r.Equal(float64(0), testutil.ToFloat64(m.status.WithLabelValues("readiness", "metrics-failing")))
+	r.Equal(float64(2), testutil.ToFloat64(m.failures.WithLabelValues("readiness", "metrics-failing")))
+	r.Equal(float64(0), testutil.ToFloat64(m.failures.WithLabelValues("readiness", "metrics-ok")))
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, `health_check_duration_seconds_count{check="metrics-failing",probe="readiness"} 2`)
+	r.Contains(data, `health_check_status{check="metrics-failing",probe="readiness"} 0`)
+}
+
+func TestHealthRegistryRegistererConflict(t *testing.T) {
+	r := require.New(t)
+
+	promRegistry := prometheus.NewRegistry()
+
+	reg := NewHealthRegistry(WithHealthRegistryRegisterer(promRegistry))
+	r.NotPanics(func() {
+		NewWithHealthRegistry(reg, WithRegisterer(promRegistry))
+	})
+
+	reg = NewHealthRegistry(WithHealthRegistryRegisterer(prometheus.NewRegistry()))
+	r.Panics(func() {
+		NewWithHealthRegistry(reg, WithRegisterer(promRegistry))
+	})
+}
+
+func TestProbeFuncMetrics(t *testing.T) {
+	r := require.New(t)
+
+	e := echo.New()
+	New(func() error { return nil }, func() error { return errors.New("blah") }, nil,
+		WithRegisterer(prometheus.NewRegistry()),
+	).Register(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	_, _, err := get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+	_, _, err = get(context.TODO(), srv.URL+readinessProbeURL)
+	r.NoError(err)
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, `health_check_status{check="default",probe="liveness"} 1`)
+	r.Contains(data, `health_check_status{check="default",probe="readiness"} 0`)
+	r.Contains(data, `health_check_failures_total{check="default",probe="readiness"} 1`)
+}
+
+func scrape(t *testing.T, url string) string {
+	r := require.New(t)
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, url, nil)
+	r.NoError(err)
+
+	resp, err := http.DefaultClient.Do(req)
+	r.NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	r.NoError(err)
+
+	return string(data)
+}
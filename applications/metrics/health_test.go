@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthRegistryEndpoints(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Liveness, "kafka", FromFunc(func() error { return nil })))
+	r.NoError(reg.Register(Readiness, "db", func(context.Context) error { return nil }))
+	r.NoError(reg.Register(Readiness, "cache", func(context.Context) error { return errors.New("blah") }, NonCritical()))
+	r.NoError(reg.Register(Startup, "kafka", func(context.Context) error { return errors.New("not started") }))
+
+	e := echo.New()
+	NewWithHealthRegistry(reg).Register(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	type testCase struct {
+		name    string
+		url     string
+		expCode int
+		expData map[string]any
+	}
+
+	tcs := []testCase{
+		{
+			name:    "liveness probe",
+			url:     livenessProbeURL,
+			expCode: http.StatusOK,
+			expData: map[string]any{
+				"status": "ok",
+				"checks": []any{
+					map[string]any{"name": "kafka", "status": "ok", "critical": true},
+				},
+			},
+		},
+		{
+			name:    "readiness probe with non-critical failure",
+			url:     readinessProbeURL,
+			expCode: http.StatusOK,
+			expData: map[string]any{
+				"status": "ok",
+				"checks": []any{
+					map[string]any{"name": "cache", "status": "failed", "critical": false, "consecutive_failures": float64(1), "error": "blah"},
+					map[string]any{"name": "db", "status": "ok", "critical": true},
+				},
+			},
+		},
+		{
+			name:    "startup probe with critical failure",
+			url:     startupProbeURL,
+			expCode: http.StatusServiceUnavailable,
+			expData: map[string]any{
+				"status": "failed",
+				"checks": []any{
+					map[string]any{"name": "kafka", "status": "failed", "critical": true, "consecutive_failures": float64(1), "error": "not started"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			code, v, err := get(context.TODO(), srv.URL+tc.url)
+			r.NoError(err)
+			r.Equal(tc.expCode, code)
+
+			for _, c := range v["checks"].([]any) {
+				check := c.(map[string]any)
+				r.NotEmpty(check["duration"])
+				delete(check, "duration")
+			}
+			r.Equal(tc.expData, v)
+		})
+	}
+}
+
+func TestHealthRegistryNoChecks(t *testing.T) {
+	r := require.New(t)
+
+	e := echo.New()
+	NewWithHealthRegistry(NewHealthRegistry()).Register(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	code, v, err := get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusNotImplemented, code)
+	r.Equal(map[string]any{
+		"status": "failed",
+		"error":  "not implemented: no checks registered",
+	}, v)
+}
+
+func TestHealthRegistryTimeout(t *testing.T) {
+	r := require.New(t)
+
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Readiness, "stuck", FromFunc(func() error {
+		<-blockCh
+		return nil
+	}), WithTimeout(50*time.Millisecond)))
+
+	result, err := reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusFailed, result.Status)
+	r.Len(result.Checks, 1)
+	r.ErrorIs(result.Checks[0].Error, context.DeadlineExceeded)
+	r.Less(result.Checks[0].Duration, time.Second)
+
+	r.ErrorContains(reg.ProbeFunc(Readiness)(), "check `stuck` failed: check timed out")
+}
+
+func TestHealthRegistryConcurrent(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewHealthRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		r.NoError(reg.Register(Liveness, name, func(ctx context.Context) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}))
+	}
+
+	start := time.Now()
+	result, err := reg.Check(context.TODO(), Liveness)
+	r.NoError(err)
+	r.Less(time.Since(start), 250*time.Millisecond)
+	r.Equal(StatusOK, result.Status)
+	r.NoError(result.Err())
+	r.NoError(reg.ProbeFunc(Liveness)())
+}
+
+func TestHealthRegistryDuplicate(t *testing.T) {
+	r := require.New(t)
+
+	fn := FromFunc(func() error { return nil })
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Liveness, "db", fn))
+	r.NoError(reg.Register(Readiness, "db", fn))
+	r.ErrorIs(reg.Register(Liveness, "db", fn), ErrDuplicateCheck)
+
+	r.ErrorIs(reg.ProbeFunc(Startup)(), ErrNoChecks)
+}
+
+func TestHealthRegistryBackground(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	calls := &atomic.Int32{}
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Readiness, "db", func(context.Context) error {
+		calls.Add(1)
+		return nil
+	}, WithBackground(20*time.Millisecond)))
+
+	result, err := reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusFailed, result.Status)
+	r.ErrorIs(result.Checks[0].Error, ErrNoResult)
+	r.Equal(int32(0), calls.Load())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- reg.Run(ctx)
+	}()
+
+	r.Eventually(func() bool {
+		return calls.Load() >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	result, err = reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusOK, result.Status)
+	r.Positive(result.Checks[0].Age)
+
+	// checks registered while running are started immediately
+	r.NoError(reg.Register(Readiness, "cache", FromFunc(func() error { return nil }), WithBackground(time.Hour)))
+	r.Eventually(func() bool {
+		result, err := reg.Check(context.TODO(), Readiness)
+		return err == nil && result.Status == StatusOK
+	}, time.Second, 5*time.Millisecond)
+
+	cancelFn()
+	r.NoError(<-errCh)
+
+	stopped := calls.Load()
+	time.Sleep(50 * time.Millisecond)
+	r.Equal(stopped, calls.Load())
+}
+
+func TestHealthRegistryStale(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Readiness, "db", FromFunc(func() error { return nil }),
+		WithBackground(time.Hour), WithTTL(50*time.Millisecond)))
+
+	go func() { _ = reg.Run(ctx) }()
+
+	r.Eventually(func() bool {
+		result, err := reg.Check(context.TODO(), Readiness)
+		return err == nil && result.Status == StatusOK
+	}, time.Second, 5*time.Millisecond)
+
+	r.Eventually(func() bool {
+		result, err := reg.Check(context.TODO(), Readiness)
+		return err == nil && result.Status == StatusFailed
+	}, time.Second, 10*time.Millisecond)
+
+	result, err := reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusStale, result.Checks[0].Status)
+	r.ErrorIs(result.Checks[0].Error, ErrStaleResult)
+}
+
+func TestHealthRegistryFailureThreshold(t *testing.T) {
+	r := require.New(t)
+
+	failing := &atomic.Bool{}
+	failing.Store(true)
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Readiness, "db", FromFunc(func() error {
+		if failing.Load() {
+			return errors.New("blah")
+		}
+		return nil
+	}), WithFailureThreshold(3)))
+
+	for i := 1; i <= 2; i++ {
+		result, err := reg.Check(context.TODO(), Readiness)
+		r.NoError(err)
+		r.Equal(StatusOK, result.Status)
+		r.Equal(i, result.Checks[0].ConsecutiveFailures)
+		r.NoError(result.Checks[0].Error)
+	}
+
+	result, err := reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusFailed, result.Status)
+	r.Equal(3, result.Checks[0].ConsecutiveFailures)
+	r.ErrorContains(result.Err(), "check `db` failed: blah")
+
+	failing.Store(false)
+
+	result, err = reg.Check(context.TODO(), Readiness)
+	r.NoError(err)
+	r.Equal(StatusOK, result.Status)
+	r.Equal(0, result.Checks[0].ConsecutiveFailures)
+}
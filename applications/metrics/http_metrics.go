@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute is the route label value for requests not matching any
+// route so the raw paths don't blow up the cardinality
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method label value for non-standard request methods
+// so the arbitrary methods sent by clients don't blow up the cardinality
+const otherMethod = "other"
+
+type httpMetrics struct {
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	inFlight     *prometheus.GaugeVec
+	responseSize *prometheus.HistogramVec
+}
+
+// newHTTPMetrics panics if the metrics couldn't be registered the same way
+// prometheus.MustRegister does
+func newHTTPMetrics(registerer prometheus.Registerer) *httpMetrics {
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total amount of HTTP requests by method, route and status class",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by method, route and status class",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Amount of HTTP requests being served by method and route",
+		}, []string{"method", "route"}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of HTTP responses by method, route and status class",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+		}, []string{"method", "route", "status"}),
+	}
+
+	var err error
+	if m.requests, err = register(registerer, m.requests); err != nil {
+		panic(err)
+	}
+
+	if m.duration, err = register(registerer, m.duration); err != nil {
+		panic(err)
+	}
+
+	if m.inFlight, err = register(registerer, m.inFlight); err != nil {
+		panic(err)
+	}
+
+	if m.responseSize, err = register(registerer, m.responseSize); err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// middleware records RED metrics of the requests labelled with the route
+// template instead of the raw path
+func (m *httpMetrics) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		method := methodLabel(c.Request().Method)
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		inFlight := m.inFlight.WithLabelValues(method, route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		err := next(c)
+
+		status := statusClass(responseStatus(c, err))
+
+		m.requests.WithLabelValues(method, route, status).Inc()
+		m.duration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+		m.responseSize.WithLabelValues(method, route, status).Observe(float64(c.Response().Size))
+
+		return err
+	}
+}
+
+// responseStatus returns the status code the error is going to be handled
+// with since the response is not written yet when the handler returns
+// the error
+func responseStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+
+	httpErr := &echo.HTTPError{}
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return otherMethod
+}
+
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	r := require.New(t)
+
+	m := New(nil, nil, nil, WithRegisterer(prometheus.NewRegistry()))
+
+	e := echo.New()
+	e.Use(m.Middleware())
+	m.Register(e)
+
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "hello")
+	})
+	e.GET("/not-found", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	})
+	e.GET("/error", func(c echo.Context) error {
+		return errors.New("blah")
+	})
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	hm := m.(*appMetrics).httpMetrics
+
+	counter := func(method, route, status string) float64 {
+		return testutil.ToFloat64(hm.requests.WithLabelValues(method, route, status))
+	}
+
+	for _, path := range []string{
+		"/users/1",
+		"/users/2",
+		"/not-found",
+		"/error",
+		"/no-such-route",
+	} {
+		req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL+path, nil)
+		r.NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		r.NoError(err)
+		r.NoError(resp.Body.Close())
+	}
+
+	r.Equal(float64(2), counter(http.MethodGet, "/users/:id", "2xx"))
+	r.Equal(float64(1), counter(http.MethodGet, "/not-found", "4xx"))
+	r.Equal(float64(1), counter(http.MethodGet, "/error", "5xx"))
+	r.Equal(float64(1), counter(http.MethodGet, unmatchedRoute, "4xx"))
+	r.Equal(float64(0), testutil.ToFloat64(hm.inFlight.WithLabelValues(http.MethodGet, "/users/:id")))
+
+	for _, method := range []string{"PROPFIND", "BLAH"} {
+		req, err := http.NewRequestWithContext(context.TODO(), method, srv.URL+"/users/1", nil)
+		r.NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		r.NoError(err)
+		r.NoError(resp.Body.Close())
+	}
+	r.Equal(float64(2), counter(otherMethod, "/users/:id", "4xx"))
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, `http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 2`)
+	r.Contains(data, `http_response_size_bytes_sum{method="GET",route="/users/:id",status="2xx"}`)
+	r.NotContains(data, `route="/users/1"`)
+	r.NotContains(data, `method="BLAH"`)
+}
+
+func TestMethodLabel(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(http.MethodGet, methodLabel(http.MethodGet))
+	r.Equal(http.MethodDelete, methodLabel(http.MethodDelete))
+	r.Equal(otherMethod, methodLabel("get"))
+	r.Equal(otherMethod, methodLabel("PROPFIND"))
+}
+
+func TestStatusClass(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("2xx", statusClass(http.StatusNoContent))
+	r.Equal("3xx", statusClass(http.StatusFound))
+	r.Equal("4xx", statusClass(http.StatusTeapot))
+	r.Equal("5xx", statusClass(http.StatusBadGateway))
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ Lifecycle = (*lifecycle)(nil)
+
+	ErrNotStarted   = errors.New("application is not started yet")
+	ErrShuttingDown = errors.New("application is shutting down")
+)
+
+// Lifecycle gates probes by the application lifecycle: startup and
+// readiness fail until MarkStarted is called, readiness fails once
+// BeginShutdown is called. Probe functions are compatible with New.
+type Lifecycle interface {
+	// MarkStarted marks the application as started, subsequent calls are
+	// no-op
+	MarkStarted()
+	// BeginShutdown fails readiness and blocks for the drain delay so load
+	// balancers stop routing traffic before the server is stopped
+	BeginShutdown(ctx context.Context) error
+
+	Startup() error
+	Readiness() error
+}
+
+type LifecycleOption func(l *lifecycle)
+
+// WithDrainDelay sets the time BeginShutdown waits after readiness started
+// to fail, no delay by default
+func WithDrainDelay(delay time.Duration) LifecycleOption {
+	return func(l *lifecycle) {
+		l.drainDelay = delay
+	}
+}
+
+type lifecycle struct {
+	drainDelay time.Duration
+
+	mutex      sync.RWMutex
+	started    bool
+	shutdownAt time.Time
+}
+
+func NewLifecycle(opts ...LifecycleOption) Lifecycle {
+	l := &lifecycle{}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+func (l *lifecycle) MarkStarted() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.started = true
+}
+
+func (l *lifecycle) BeginShutdown(ctx context.Context) error {
+	l.mutex.Lock()
+	if l.shutdownAt.IsZero() {
+		l.shutdownAt = time.Now()
+	}
+	drainUntil := l.shutdownAt.Add(l.drainDelay)
+	l.mutex.Unlock()
+
+	timer := time.NewTimer(time.Until(drainUntil))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "drain delay interrupted")
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *lifecycle) Startup() error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if !l.started {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+func (l *lifecycle) Readiness() error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if !l.shutdownAt.IsZero() {
+		return ErrShuttingDown
+	}
+
+	if !l.started {
+		return ErrNotStarted
+	}
+	return nil
+}
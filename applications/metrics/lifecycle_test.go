@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	r := require.New(t)
+
+	l := NewLifecycle()
+	r.ErrorIs(l.Startup(), ErrNotStarted)
+	r.ErrorIs(l.Readiness(), ErrNotStarted)
+
+	l.MarkStarted()
+	r.NoError(l.Startup())
+	r.NoError(l.Readiness())
+
+	r.NoError(l.BeginShutdown(context.TODO()))
+	r.NoError(l.Startup())
+	r.ErrorIs(l.Readiness(), ErrShuttingDown)
+}
+
+func TestLifecycleDrainDelay(t *testing.T) {
+	r := require.New(t)
+
+	l := NewLifecycle(WithDrainDelay(100 * time.Millisecond))
+	l.MarkStarted()
+
+	start := time.Now()
+	r.NoError(l.BeginShutdown(context.TODO()))
+	r.GreaterOrEqual(time.Since(start), 100*time.Millisecond)
+	r.ErrorIs(l.Readiness(), ErrShuttingDown)
+
+	// the delay is counted from the first call
+	start = time.Now()
+	r.NoError(l.BeginShutdown(context.TODO()))
+	r.Less(time.Since(start), 100*time.Millisecond)
+}
+
+func TestLifecycleDrainDelayCancelled(t *testing.T) {
+	r := require.New(t)
+
+	l := NewLifecycle(WithDrainDelay(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+
+	err := l.BeginShutdown(ctx)
+	r.ErrorIs(err, context.DeadlineExceeded)
+	r.ErrorIs(l.Readiness(), ErrShuttingDown)
+}
+
+func TestLifecycleProbes(t *testing.T) {
+	r := require.New(t)
+
+	l := NewLifecycle()
+	m := New(func() error { return nil }, func() error { return nil }, nil,
+		WithRegisterer(prometheus.NewRegistry()),
+		WithLifecycle(l),
+	)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	type testCase struct {
+		url    string
+		code   int
+		status string
+		err    string
+	}
+
+	check := func(tcs []testCase) {
+		for _, tc := range tcs {
+			code, v, err := get(context.TODO(), srv.URL+tc.url)
+			r.NoError(err)
+			r.Equal(tc.code, code, tc.url)
+			r.Equal(tc.status, v["status"], tc.url)
+			if tc.err != "" {
+				r.Equal(tc.err, v["error"], tc.url)
+			}
+		}
+	}
+
+	check([]testCase{
+		{url: livenessProbeURL, code: http.StatusOK, status: "ok"},
+		{url: readinessProbeURL, code: http.StatusServiceUnavailable, status: "failed", err: ErrNotStarted.Error()},
+		{url: startupProbeURL, code: http.StatusServiceUnavailable, status: "failed", err: ErrNotStarted.Error()},
+	})
+
+	l.MarkStarted()
+	check([]testCase{
+		{url: livenessProbeURL, code: http.StatusOK, status: "ok"},
+		{url: readinessProbeURL, code: http.StatusOK, status: "ok"},
+		{url: startupProbeURL, code: http.StatusOK, status: "ok"},
+	})
+
+	r.NoError(l.BeginShutdown(context.TODO()))
+	check([]testCase{
+		{url: livenessProbeURL, code: http.StatusOK, status: "ok"},
+		{url: readinessProbeURL, code: http.StatusServiceUnavailable, status: "failed", err: ErrShuttingDown.Error()},
+		{url: startupProbeURL, code: http.StatusOK, status: "ok"},
+	})
+}
+
+func TestLifecycleHealthRegistry(t *testing.T) {
+	r := require.New(t)
+
+	registry := NewHealthRegistry()
+	r.NoError(registry.Register(Readiness, "db", func(context.Context) error { return nil }))
+
+	l := NewLifecycle()
+	l.MarkStarted()
+
+	m := NewWithHealthRegistry(registry,
+		WithRegisterer(prometheus.NewRegistry()),
+		WithLifecycle(l),
+	)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	code, v, err := get(context.TODO(), srv.URL+readinessProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Len(v["checks"], 1)
+
+	code, v, err = get(context.TODO(), srv.URL+startupProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal(map[string]any{"status": "ok"}, v)
+
+	code, _, err = get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusNotImplemented, code)
+}
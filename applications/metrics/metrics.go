@@ -1,9 +1,15 @@
 package metrics
 
 import (
+	"encoding/json"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -15,58 +21,257 @@ const (
 )
 
 type AppMetrics interface {
+	// Register registers endpoints in echo router
 	Register(e *echo.Echo)
+	// RegisterMux registers endpoints in net/http ServeMux. build_info
+	// metric is registered on the first call.
+	RegisterMux(mux *http.ServeMux)
+	Handler() http.Handler
+	// Middleware returns echo middleware recording HTTP requests metrics,
+	// it's expected to be used with echo.Echo.Use
+	Middleware() echo.MiddlewareFunc
 }
 
 type appMetrics struct {
 	livenessProbeFn  func() error
 	readinessProbeFn func() error
 	startupProbeFn   func() error
+	registry         HealthRegistry
+	healthMetrics    *healthMetrics
+	lifecycle        Lifecycle
+
+	registerer  prometheus.Registerer
+	gatherers   prometheus.Gatherers
+	handlerOpts *promhttp.HandlerOpts
+
+	httpMetricsOnce sync.Once
+	httpMetrics     *httpMetrics
+	buildInfoOnce   sync.Once
+}
+
+func New(livenessProbeFn, readinessProbeFn, startupProbeFn func() error, opts ...Option) AppMetrics {
+	m := newAppMetrics(opts...)
+	m.livenessProbeFn = livenessProbeFn
+	m.readinessProbeFn = readinessProbeFn
+	m.startupProbeFn = startupProbeFn
+	m.healthMetrics = newHealthMetrics(m.registerer)
+
+	return m
+}
+
+// NewWithHealthRegistry creates AppMetrics serving probes with the checks
+// of the registry. Probe responses list the result of each check. Check
+// metrics are recorded with the registerer set by WithRegisterer, it panics
+// if the registry is created with the different one.
+func NewWithHealthRegistry(registry HealthRegistry, opts ...Option) AppMetrics {
+	m := newAppMetrics(opts...)
+	m.registry = registry
+
+	if r, ok := registry.(*healthRegistry); ok {
+		r.useRegisterer(m.registerer)
+	}
+
+	return m
+}
+
+func newAppMetrics(opts ...Option) *appMetrics {
+	m := &appMetrics{
+		registerer: prometheus.DefaultRegisterer,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+func (m *appMetrics) probe(probe Probe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := newProbeRequest(r)
+		known := m.checkNames(probe)
+
+		if len(known) == 0 {
+			msg := "not implemented: check function is not provided"
+			if m.registry != nil {
+				msg = ErrNoChecks.Error()
+			}
+
+			req.write(w, http.StatusNotImplemented, probe, nil, known, map[string]string{
+				"status": StatusFailed, "error": msg,
+			})
+			return
+		}
+
+		if req.only != "" && !slices.Contains(known, req.only) {
+			err := errors.Wrapf(ErrUnknownCheck, "%s check `%s`", probe, req.only)
+			req.write(w, http.StatusNotFound, probe, nil, known, map[string]string{
+				"status": StatusFailed, "error": err.Error(),
+			})
+			return
+		}
+
+		// lifecycle failure fails the probe before running any checks
+		var checks []CheckResult
+		if m.lifecycle != nil && probe != Liveness && req.includes(lifecycleCheckName) {
+			c := m.lifecycleCheck(probe)
+			if c.Error != nil {
+				req.write(w, http.StatusServiceUnavailable, probe, []CheckResult{c}, known, map[string]string{
+					"status": StatusFailed, "error": c.Error.Error(),
+				})
+				return
+			}
+			checks = append(checks, c)
+		}
+
+		var v any = map[string]string{"status": StatusOK}
+		switch {
+		case m.registry != nil && len(m.registry.Names(probe)) > 0:
+			result, err := m.registry.Check(r.Context(), probe, req.filters()...)
+			if err != nil {
+				req.write(w, http.StatusInternalServerError, probe, checks, known, map[string]string{
+					"status": StatusFailed, "error": err.Error(),
+				})
+				return
+			}
+
+			checks = append(checks, result.Checks...)
+			if len(result.Checks) > 0 {
+				v = result
+			}
+		case m.registry == nil && m.probeFn(probe) != nil && req.includes(defaultCheckName):
+			c := m.runFunc(probe, m.probeFn(probe))
+			checks = append(checks, c)
+			if c.Error != nil {
+				v = map[string]string{"status": StatusFailed, "error": c.Error.Error()}
+			}
+		}
+
+		code := http.StatusOK
+		if newProbeResult(checks).Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		req.write(w, code, probe, checks, known, v)
+	}
 }
 
-func New(livenessProbeFn, readinessProbeFn, startupProbeFn func() error) AppMetrics {
-	return &appMetrics{
-		livenessProbeFn:  livenessProbeFn,
-		readinessProbeFn: readinessProbeFn,
-		startupProbeFn:   startupProbeFn,
+func (m *appMetrics) probeFn(probe Probe) func() error {
+	switch probe {
+	case Liveness:
+		return m.livenessProbeFn
+	case Readiness:
+		return m.readinessProbeFn
+	case Startup:
+		return m.startupProbeFn
+	default:
+		return nil
 	}
 }
 
-func (m *appMetrics) livenessProbe(c echo.Context) error {
-	return check(c, m.livenessProbeFn)
+// checkNames returns names of all the checks of the probe including the
+// lifecycle
+func (m *appMetrics) checkNames(probe Probe) []string {
+	var names []string
+	if m.lifecycle != nil && probe != Liveness {
+		names = append(names, lifecycleCheckName)
+	}
+
+	if m.registry != nil {
+		return append(names, m.registry.Names(probe)...)
+	}
+
+	if m.probeFn(probe) != nil {
+		names = append(names, defaultCheckName)
+	}
+	return names
 }
 
-func (m *appMetrics) readinessProbe(c echo.Context) error {
-	return check(c, m.readinessProbeFn)
+func (m *appMetrics) lifecycleCheck(probe Probe) CheckResult {
+	fn := m.lifecycle.Readiness
+	if probe == Startup {
+		fn = m.lifecycle.Startup
+	}
+
+	c := CheckResult{
+		Name:     lifecycleCheckName,
+		Status:   StatusOK,
+		Critical: true,
+		Error:    fn(),
+	}
+	if c.Error != nil {
+		c.Status = StatusFailed
+	}
+	return c
 }
 
-func (m *appMetrics) startupProbe(c echo.Context) error {
-	return check(c, m.startupProbeFn)
+// Handler returns http.Handler serving probes and metrics endpoints to be
+// used with any router
+func (m *appMetrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	m.RegisterMux(mux)
+	return mux
 }
 
-func (m *appMetrics) metrics(c echo.Context) error {
-	return echo.WrapHandler(promhttp.Handler())(c)
+func (m *appMetrics) RegisterMux(mux *http.ServeMux) {
+	m.buildInfoOnce.Do(func() {
+		registerBuildInfo(m.registerer)
+	})
+
+	for url, probe := range map[string]Probe{
+		livenessProbeURL:  Liveness,
+		readinessProbeURL: Readiness,
+		startupProbeURL:   Startup,
+	} {
+		mux.HandleFunc(http.MethodGet+" "+url, m.probe(probe))
+		mux.HandleFunc(http.MethodGet+" "+url+"/{check}", m.probe(probe))
+	}
+	mux.Handle(http.MethodGet+" "+metricsURL, m.metricsHandler())
+	mux.HandleFunc(http.MethodGet+" "+versionURL, buildInfo)
 }
 
-func (m *appMetrics) Register(e *echo.Echo) {
-	e.GET(livenessProbeURL, m.livenessProbe)
-	e.GET(readinessProbeURL, m.readinessProbe)
-	e.GET(startupProbeURL, m.startupProbe)
-	e.GET(metricsURL, m.metrics)
+func (m *appMetrics) Middleware() echo.MiddlewareFunc {
+	m.httpMetricsOnce.Do(func() {
+		m.httpMetrics = newHTTPMetrics(m.registerer)
+	})
+	return m.httpMetrics.middleware
 }
 
-func check(c echo.Context, fn func() error) error {
-	if fn == nil {
-		return c.JSON(http.StatusNotImplemented, echo.Map{
-			"status": "failed", "error": "not implemented: check function is not provided",
-		})
+func (m *appMetrics) Register(e *echo.Echo) {
+	h := echo.WrapHandler(m.Handler())
+
+	for _, url := range []string{livenessProbeURL, readinessProbeURL, startupProbeURL} {
+		e.GET(url, h)
+		e.GET(url+"/:check", h)
 	}
+	e.GET(metricsURL, h)
+	e.GET(versionURL, h)
+}
 
-	if err := fn(); err != nil {
-		return echo.NewHTTPError(http.StatusServiceUnavailable, echo.Map{
-			"status": "failed", "error": err.Error(),
-		}).SetInternal(err)
+func (m *appMetrics) runFunc(probe Probe, fn func() error) CheckResult {
+	start := time.Now()
+	err := fn()
+	d := time.Since(start)
+
+	m.healthMetrics.observeRun(probe, defaultCheckName, d, err)
+	m.healthMetrics.setStatus(probe, defaultCheckName, err == nil)
+
+	c := CheckResult{
+		Name:     defaultCheckName,
+		Status:   StatusOK,
+		Critical: true,
+		Duration: d,
+		Error:    err,
+	}
+	if err != nil {
+		c.Status = StatusFailed
 	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 
-	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+	// the status is already sent so there's nothing to do with the error
+	_ = json.NewEncoder(w).Encode(v)
 }
@@ -12,6 +12,7 @@ import (
 	echo "github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -124,30 +125,82 @@ func TestAll(t *testing.T) {
 		},
 	}
 
-	for _, tc := range tcs {
-		t.Run(tc.name, func(t *testing.T) {
-			r := require.New(t)
-
+	routers := map[string]func(m AppMetrics) http.Handler{
+		"echo": func(m AppMetrics) http.Handler {
 			e := echo.New()
 			e.Use(middleware.RequestLogger())
 			e.Use(middleware.Recover())
 
-			appMetrics := New(tc.livenessProbeFn, tc.readinessProbeFn, tc.startupProbeFn)
-			appMetrics.Register(e)
+			m.Register(e)
+			return e
+		},
+		"mux": func(m AppMetrics) http.Handler {
+			mux := http.NewServeMux()
+			m.RegisterMux(mux)
+			return mux
+		},
+		"handler": func(m AppMetrics) http.Handler {
+			return m.Handler()
+		},
+	}
 
-			srv := httptest.NewServer(e)
-			defer srv.Close()
+	for _, tc := range tcs {
+		for routerName, routerFn := range routers {
+			t.Run(tc.name+" with "+routerName, func(t *testing.T) {
+				r := require.New(t)
 
-			ctx := context.TODO()
+				appMetrics := New(tc.livenessProbeFn, tc.readinessProbeFn, tc.startupProbeFn,
+					WithRegisterer(prometheus.NewRegistry()),
+				)
 
-			code, v, err := get(ctx, srv.URL+tc.url)
-			r.NoError(err)
-			r.Equal(tc.expCode, code)
-			r.Equal(tc.expData, v)
-		})
+				srv := httptest.NewServer(routerFn(appMetrics))
+				defer srv.Close()
+
+				ctx := context.TODO()
+
+				code, v, err := get(ctx, srv.URL+tc.url)
+				r.NoError(err)
+				r.Equal(tc.expCode, code)
+				r.Equal(tc.expData, v)
+			})
+		}
 	}
 }
 
+func TestMux(t *testing.T) {
+	r := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	New(func() error { return nil }, nil, nil).RegisterMux(mux)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	code, v, err := get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal(map[string]any{"status": "ok"}, v)
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, srv.URL+livenessProbeURL, nil)
+	r.NoError(err)
+
+	resp, err := http.DefaultClient.Do(req)
+	r.NoError(err)
+	r.NoError(resp.Body.Close())
+	r.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	req, err = http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL+"/api", nil)
+	r.NoError(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	r.NoError(err)
+	r.NoError(resp.Body.Close())
+	r.Equal(http.StatusNoContent, resp.StatusCode)
+}
+
 func TestMetrics(t *testing.T) {
 	r := require.New(t)
 
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Option func(m *appMetrics)
+
+// WithRegisterer sets the registerer for probe and HTTP metrics,
+// prometheus.DefaultRegisterer is used by default. The registerer is also
+// used as the gatherer for /metrics if it implements prometheus.Gatherer and
+// no gatherers are set with WithGatherers.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(m *appMetrics) {
+		m.registerer = registerer
+	}
+}
+
+// WithGatherers sets gatherers served by /metrics, the metrics of all
+// of them are merged
+func WithGatherers(gatherers ...prometheus.Gatherer) Option {
+	return func(m *appMetrics) {
+		m.gatherers = append(m.gatherers, gatherers...)
+	}
+}
+
+// WithHandlerOpts sets the options of /metrics handler: OpenMetrics
+// exposition, compression, error handling, timeout, etc.
+func WithHandlerOpts(opts promhttp.HandlerOpts) Option {
+	return func(m *appMetrics) {
+		m.handlerOpts = &opts
+	}
+}
+
+// WithLifecycle gates startup and readiness probes by the lifecycle, probes
+// without checks are served by the lifecycle only
+func WithLifecycle(l Lifecycle) Option {
+	return func(m *appMetrics) {
+		m.lifecycle = l
+	}
+}
+
+// metricsHandler returns promhttp.Handler if nothing is customized
+func (m *appMetrics) metricsHandler() http.Handler {
+	if m.registerer == prometheus.DefaultRegisterer && len(m.gatherers) == 0 && m.handlerOpts == nil {
+		return promhttp.Handler()
+	}
+
+	var gatherer prometheus.Gatherer = m.gatherers
+	if len(m.gatherers) == 0 {
+		gatherer = prometheus.DefaultGatherer
+		if g, ok := m.registerer.(prometheus.Gatherer); ok {
+			gatherer = g
+		}
+	}
+
+	opts := promhttp.HandlerOpts{}
+	if m.handlerOpts != nil {
+		opts = *m.handlerOpts
+	}
+
+	return promhttp.InstrumentMetricHandler(m.registerer, promhttp.HandlerFor(gatherer, opts))
+}
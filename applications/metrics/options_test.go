@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomRegistry(t *testing.T) {
+	r := require.New(t)
+
+	registry := prometheus.NewRegistry()
+
+	m := New(func() error { return nil }, nil, nil, WithRegisterer(registry))
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	_, _, err := get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, `health_check_status{check="default",probe="liveness"} 1`)
+	r.Contains(data, "promhttp_metric_handler_requests_total")
+	r.NotContains(data, "go_goroutines")
+}
+
+func TestMultipleGatherers(t *testing.T) {
+	r := require.New(t)
+
+	appRegistry := prometheus.NewRegistry()
+	componentRegistry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "component_events_total",
+		Help: "Test counter",
+	})
+	componentRegistry.MustRegister(counter)
+	counter.Inc()
+
+	m := New(func() error { return nil }, nil, nil,
+		WithRegisterer(appRegistry),
+		WithGatherers(appRegistry, componentRegistry),
+	)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	_, _, err := get(context.TODO(), srv.URL+livenessProbeURL)
+	r.NoError(err)
+
+	data := scrape(t, srv.URL+metricsURL)
+	r.Contains(data, "component_events_total 1")
+	r.Contains(data, `health_check_status{check="default",probe="liveness"} 1`)
+}
+
+func TestHandlerOpts(t *testing.T) {
+	r := require.New(t)
+
+	m := New(nil, nil, nil,
+		WithRegisterer(prometheus.NewRegistry()),
+		WithHandlerOpts(promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, srv.URL+metricsURL, nil)
+	r.NoError(err)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	r.NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	r.NoError(err)
+	r.Contains(resp.Header.Get("Content-Type"), "application/openmetrics-text")
+	r.Contains(string(data), "# EOF")
+}
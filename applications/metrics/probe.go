@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lifecycleCheckName is the name the lifecycle is reported with in verbose
+// output, it could be excluded or requested with the sub-path as any other
+// check
+const lifecycleCheckName = "lifecycle"
+
+// probeRequest is the Kubernetes API server style probe request:
+//
+//	/healthz/readiness?verbose             lists each check with [+]/[-]
+//	/healthz/readiness?exclude=db          skips the check named db
+//	/healthz/readiness/db                  runs the check named db only
+type probeRequest struct {
+	verbose bool
+	exclude []string
+	only    string
+}
+
+func newProbeRequest(r *http.Request) probeRequest {
+	q := r.URL.Query()
+
+	req := probeRequest{
+		exclude: q["exclude"],
+		only:    r.PathValue("check"),
+	}
+
+	if q.Has("verbose") {
+		// bare ?verbose enables the verbose output as well
+		v, err := strconv.ParseBool(q.Get("verbose"))
+		req.verbose = err != nil || v
+	}
+
+	return req
+}
+
+func (req probeRequest) includes(name string) bool {
+	if req.only != "" && req.only != name {
+		return false
+	}
+	return !slices.Contains(req.exclude, name)
+}
+
+func (req probeRequest) filters() []CheckFilter {
+	filters := []CheckFilter{Exclude(req.exclude...)}
+	if req.only != "" {
+		filters = append(filters, Only(req.only))
+	}
+	return filters
+}
+
+// write responds with v as JSON or with the text listing of the checks if
+// verbose output is requested
+func (req probeRequest) write(w http.ResponseWriter, code int, probe Probe, checks []CheckResult, known []string, v any) {
+	if !req.verbose {
+		writeJSON(w, code, v)
+		return
+	}
+
+	sb := &strings.Builder{}
+	for _, c := range checks {
+		if c.Error == nil {
+			fmt.Fprintf(sb, "[+]%s %s\n", c.Name, c.Status)
+			continue
+		}
+
+		status := c.Status
+		if !c.Critical {
+			status += " (non-critical)"
+		}
+		fmt.Fprintf(sb, "[-]%s %s: %s\n", c.Name, status, c.Error)
+	}
+
+	var unknown []string
+	for _, name := range req.exclude {
+		if !slices.Contains(known, name) {
+			unknown = append(unknown, strconv.Quote(name))
+		}
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(sb, "warn: some health checks cannot be excluded: no matches for %s\n", strings.Join(unknown, ","))
+	}
+
+	result := "passed"
+	if code != http.StatusOK {
+		result = "failed"
+	}
+	fmt.Fprintf(sb, "%s check %s\n", probe, result)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+
+	// the status is already sent so there's nothing to do with the error
+	_, _ = w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeVerbose(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Liveness, "ping", func(context.Context) error { return nil }))
+	r.NoError(reg.Register(Readiness, "db", func(context.Context) error { return nil }))
+	r.NoError(reg.Register(Readiness, "cache", func(context.Context) error { return errors.New("blah") }, NonCritical()))
+	r.NoError(reg.Register(Readiness, "kafka", func(context.Context) error { return errors.New("no brokers") }))
+
+	l := NewLifecycle()
+	l.MarkStarted()
+
+	e := echo.New()
+	NewWithHealthRegistry(reg, WithRegisterer(prometheus.NewRegistry()), WithLifecycle(l)).Register(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	type testCase struct {
+		name    string
+		url     string
+		expCode int
+		expData string
+	}
+
+	tcs := []testCase{
+		{
+			name:    "liveness",
+			url:     livenessProbeURL + "?verbose",
+			expCode: http.StatusOK,
+			expData: "[+]ping ok\n" +
+				"liveness check passed\n",
+		},
+		{
+			name:    "readiness",
+			url:     readinessProbeURL + "?verbose",
+			expCode: http.StatusServiceUnavailable,
+			expData: "[+]lifecycle ok\n" +
+				"[-]cache failed (non-critical): blah\n" +
+				"[+]db ok\n" +
+				"[-]kafka failed: no brokers\n" +
+				"readiness check failed\n",
+		},
+		{
+			name:    "readiness with exclude",
+			url:     readinessProbeURL + "?verbose=true&exclude=kafka&exclude=lifecycle",
+			expCode: http.StatusOK,
+			expData: "[-]cache failed (non-critical): blah\n" +
+				"[+]db ok\n" +
+				"readiness check passed\n",
+		},
+		{
+			name:    "readiness with unknown exclude",
+			url:     readinessProbeURL + "?verbose&exclude=kafka&exclude=blah",
+			expCode: http.StatusOK,
+			expData: "[+]lifecycle ok\n" +
+				"[-]cache failed (non-critical): blah\n" +
+				"[+]db ok\n" +
+				"warn: some health checks cannot be excluded: no matches for \"blah\"\n" +
+				"readiness check passed\n",
+		},
+		{
+			name:    "single check",
+			url:     readinessProbeURL + "/db?verbose",
+			expCode: http.StatusOK,
+			expData: "[+]db ok\n" +
+				"readiness check passed\n",
+		},
+		{
+			name:    "single failed check",
+			url:     readinessProbeURL + "/kafka?verbose",
+			expCode: http.StatusServiceUnavailable,
+			expData: "[-]kafka failed: no brokers\n" +
+				"readiness check failed\n",
+		},
+		{
+			name:    "lifecycle check",
+			url:     readinessProbeURL + "/lifecycle?verbose",
+			expCode: http.StatusOK,
+			expData: "[+]lifecycle ok\n" +
+				"readiness check passed\n",
+		},
+		{
+			name:    "unknown check",
+			url:     readinessProbeURL + "/blah?verbose",
+			expCode: http.StatusNotFound,
+			expData: "readiness check failed\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			code, data, err := getText(context.TODO(), srv.URL+tc.url)
+			r.NoError(err)
+			r.Equal(tc.expCode, code)
+			r.Equal(tc.expData, data)
+		})
+	}
+
+	r.NoError(l.BeginShutdown(context.TODO()))
+
+	code, data, err := getText(context.TODO(), srv.URL+readinessProbeURL+"?verbose")
+	r.NoError(err)
+	r.Equal(http.StatusServiceUnavailable, code)
+	r.Equal("[-]lifecycle failed: application is shutting down\nreadiness check failed\n", data)
+
+	code, data, err = getText(context.TODO(), srv.URL+readinessProbeURL+"/db?verbose")
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal("[+]db ok\nreadiness check passed\n", data)
+}
+
+func TestProbeSubPath(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewHealthRegistry()
+	r.NoError(reg.Register(Readiness, "db", func(context.Context) error { return nil }))
+	r.NoError(reg.Register(Readiness, "kafka", func(context.Context) error { return errors.New("no brokers") }))
+
+	srv := httptest.NewServer(NewWithHealthRegistry(reg, WithRegisterer(prometheus.NewRegistry())).Handler())
+	defer srv.Close()
+
+	code, v, err := get(context.TODO(), srv.URL+readinessProbeURL+"/db")
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal("ok", v["status"])
+	r.Len(v["checks"], 1)
+
+	code, v, err = get(context.TODO(), srv.URL+readinessProbeURL+"?exclude=db")
+	r.NoError(err)
+	r.Equal(http.StatusServiceUnavailable, code)
+	r.Equal("failed", v["status"])
+	r.Len(v["checks"], 1)
+
+	code, v, err = get(context.TODO(), srv.URL+readinessProbeURL+"?exclude=db&exclude=kafka")
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal(map[string]any{"status": "ok"}, v)
+
+	code, v, err = get(context.TODO(), srv.URL+readinessProbeURL+"/blah")
+	r.NoError(err)
+	r.Equal(http.StatusNotFound, code)
+	r.Equal(map[string]any{
+		"status": "failed",
+		"error":  "readiness check `blah`: check is not registered",
+	}, v)
+
+	code, v, err = get(context.TODO(), srv.URL+readinessProbeURL+"?verbose=false")
+	r.NoError(err)
+	r.Equal(http.StatusServiceUnavailable, code)
+	r.Equal("failed", v["status"])
+}
+
+func TestProbeFuncVerbose(t *testing.T) {
+	r := require.New(t)
+
+	m := New(func() error { return nil }, func() error { return errors.New("blah") }, nil,
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	code, data, err := getText(context.TODO(), srv.URL+livenessProbeURL+"?verbose")
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal("[+]default ok\nliveness check passed\n", data)
+
+	code, data, err = getText(context.TODO(), srv.URL+readinessProbeURL+"/default?verbose")
+	r.NoError(err)
+	r.Equal(http.StatusServiceUnavailable, code)
+	r.Equal("[-]default failed: blah\nreadiness check failed\n", data)
+
+	code, v, err := get(context.TODO(), srv.URL+readinessProbeURL+"?exclude=default")
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal(map[string]any{"status": "ok"}, v)
+
+	code, data, err = getText(context.TODO(), srv.URL+startupProbeURL+"?verbose")
+	r.NoError(err)
+	r.Equal(http.StatusNotImplemented, code)
+	r.Equal("startup check failed\n", data)
+}
+
+func TestCheckFilters(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewHealthRegistry(WithHealthRegistryRegisterer(prometheus.NewRegistry()))
+	for _, name := range []string{"a", "b", "c"} {
+		r.NoError(reg.Register(Readiness, name, func(context.Context) error { return nil }))
+	}
+
+	names := func(filters ...CheckFilter) []string {
+		result, err := reg.Check(context.TODO(), Readiness, filters...)
+		r.NoError(err)
+
+		out := []string{}
+		for _, c := range result.Checks {
+			out = append(out, c.Name)
+		}
+		return out
+	}
+
+	r.Equal([]string{"a", "b", "c"}, names())
+	r.Equal([]string{"b"}, names(Only("b")))
+	r.Equal([]string{"a", "c"}, names(Exclude("b")))
+	r.Equal([]string{"c"}, names(Only("b", "c"), Exclude("b")))
+	r.Equal([]string{}, names(Exclude("a", "b", "c")))
+	r.Equal([]string{"a", "b", "c"}, reg.Names(Readiness))
+
+	_, err := reg.Check(context.TODO(), Startup, Only("a"))
+	r.ErrorIs(err, ErrNoChecks)
+}
+
+func getText(ctx context.Context, url string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return resp.StatusCode, string(data), nil
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pprofURL     = "/debug/pprof/"
+	buildInfoURL = "/debug/buildinfo"
+
+	defaultReadTimeout     = 5 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+var _ Server = (*server)(nil)
+
+// Server is the admin HTTP server serving probes and metrics endpoints on
+// the separate address
+type Server interface {
+	// Run listens on the address and serves requests until the context is
+	// done, then shuts the server down gracefully
+	Run(ctx context.Context) error
+	// Serve is the same as Run but uses the listener provided
+	Serve(ctx context.Context, l net.Listener) error
+}
+
+type ServerOption func(s *server)
+
+// WithPprof enables net/http/pprof endpoints under /debug/pprof/
+func WithPprof() ServerOption {
+	return func(s *server) {
+		s.pprof = true
+	}
+}
+
+// WithBuildInfo enables /debug/buildinfo endpoint serving the same build
+// information as /version
+func WithBuildInfo() ServerOption {
+	return func(s *server) {
+		s.buildInfo = true
+	}
+}
+
+// WithReadTimeout sets the maximum duration for reading the entire request,
+// 5s by default
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(s *server) {
+		s.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the maximum duration before timing out writes of
+// the response, 30s by default to let CPU profiles complete
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(s *server) {
+		s.writeTimeout = timeout
+	}
+}
+
+// WithShutdownTimeout sets the time given to in-flight requests to complete
+// on shutdown, 10s by default
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(s *server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+type server struct {
+	addr    string
+	metrics AppMetrics
+
+	pprof           bool
+	buildInfo       bool
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	shutdownTimeout time.Duration
+}
+
+func NewServer(addr string, m AppMetrics, opts ...ServerOption) Server {
+	s := &server{
+		addr:    addr,
+		metrics: m,
+
+		readTimeout:     defaultReadTimeout,
+		writeTimeout:    defaultWriteTimeout,
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "error listening on `%s`", s.addr)
+	}
+
+	return s.Serve(ctx, l)
+}
+
+func (s *server) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadTimeout:       s.readTimeout,
+		ReadHeaderTimeout: s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errCh:
+		return errors.Wrap(err, "error serving admin server")
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancelFn := context.WithTimeout(context.WithoutCancel(ctx), s.shutdownTimeout)
+	defer cancelFn()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "error shutting down admin server")
+	}
+
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "error serving admin server")
+	}
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	s.metrics.RegisterMux(mux)
+
+	if s.pprof {
+		mux.HandleFunc(pprofURL, pprof.Index)
+		mux.HandleFunc(pprofURL+"cmdline", pprof.Cmdline)
+		mux.HandleFunc(pprofURL+"profile", pprof.Profile)
+		mux.HandleFunc(pprofURL+"symbol", pprof.Symbol)
+		mux.HandleFunc(pprofURL+"trace", pprof.Trace)
+	}
+
+	if s.buildInfo {
+		mux.HandleFunc(http.MethodGet+" "+buildInfoURL, buildInfo)
+	}
+
+	return mux
+}
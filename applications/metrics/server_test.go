@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+
+	srv := NewServer("", New(func() error { return nil }, nil, nil),
+		WithPprof(),
+		WithBuildInfo(),
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(time.Second),
+		WithShutdownTimeout(time.Second),
+	)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ctx, l)
+	}()
+
+	url := "http://" + l.Addr().String()
+
+	code, v, err := get(ctx, url+livenessProbeURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.Equal(map[string]any{"status": "ok"}, v)
+
+	code, v, err = get(ctx, url+buildInfoURL)
+	r.NoError(err)
+	r.Equal(http.StatusOK, code)
+	r.NotEmpty(v["go_version"])
+
+	r.Contains(scrape(t, url+metricsURL), "# HELP")
+	r.Contains(scrape(t, url+pprofURL), "goroutine")
+
+	cancelFn()
+	r.NoError(<-errCh)
+
+	_, _, err = get(context.TODO(), url+livenessProbeURL)
+	r.Error(err)
+}
+
+func TestServerDefaults(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+
+	srv := NewServer("", New(nil, nil, nil))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ctx, l)
+	}()
+
+	url := "http://" + l.Addr().String()
+
+	for _, path := range []string{pprofURL, buildInfoURL} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+path, nil)
+		r.NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		r.NoError(err)
+		r.NoError(resp.Body.Close())
+		r.Equal(http.StatusNotFound, resp.StatusCode)
+	}
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestServerListenError(t *testing.T) {
+	r := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer func() { _ = l.Close() }()
+
+	err = NewServer(l.Addr().String(), New(nil, nil, nil)).Run(context.TODO())
+	r.ErrorContains(err, "error listening on")
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/producer"
+)
+
+const (
+	// NotBeforeHeader holds the time in unix milliseconds the message must
+	// not be processed before
+	NotBeforeHeader = "x-not-before"
+	// RetryAttemptHeader holds the number of the retry topics the message
+	// passed through
+	RetryAttemptHeader = "x-retry-attempt"
+	// RetryTopicHeader holds the topic the message was originally consumed from
+	RetryTopicHeader = "x-retry-original-topic"
+	RetryErrorHeader = "x-retry-error"
+)
+
+// NotBeforeFunc returns the time the message must not be processed before.
+// Zero time means the message is processed immediately.
+type NotBeforeFunc func(msg *sarama.ConsumerMessage) time.Time
+
+// NotBeforeFromHeader reads the time in unix milliseconds from the header.
+// Messages without the header or with malformed value are not delayed.
+func NotBeforeFromHeader(name string) NotBeforeFunc {
+	return func(msg *sarama.ConsumerMessage) time.Time {
+		v, ok := producer.Header(msg, name)
+		if !ok {
+			return time.Time{}
+		}
+
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"header":    name,
+			}).Warn("malformed not-before header value. Processing message immediately ...")
+			return time.Time{}
+		}
+		return time.UnixMilli(ms)
+	}
+}
+
+// NotBeforeFromTimestamp delays each message for the duration since its
+// timestamp
+func NotBeforeFromTimestamp(delay time.Duration) NotBeforeFunc {
+	return func(msg *sarama.ConsumerMessage) time.Time {
+		if msg.Timestamp.IsZero() {
+			return time.Time{}
+		}
+		return msg.Timestamp.Add(delay)
+	}
+}
+
+// WithDelay enables delayed processing: the message is passed to the handler
+// not before the time returned by fn. The partition is paused while waiting
+// so the consumer doesn't fetch messages which are not due anyway.
+func WithDelay(fn NotBeforeFunc) Option {
+	return func(s *service) {
+		s.notBefore = fn
+	}
+}
+
+// waitDue blocks until the message is due. It returns false if the stop
+// was requested in the meantime so the message must be left unprocessed.
+func (h *consumerGroupHandler) waitDue(ctx context.Context, msg *sarama.ConsumerMessage) (bool, error) {
+	if h.notBefore == nil {
+		return true, nil
+	}
+
+	d := time.Until(h.notBefore(msg))
+	if d <= 0 {
+		return true, nil
+	}
+
+	log.WithFields(log.Fields{
+		"component": "ConsumerGroupHandler",
+		"topic":     msg.Topic,
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+		"delay":     d.String(),
+	}).Debug("message is not due yet. Pausing partition ...")
+
+	h.flow.hold(msg.Topic, msg.Partition)
+	defer h.flow.release(msg.Topic, msg.Partition)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-h.stopCh:
+		return false, nil
+	case <-ctx.Done():
+		return false, errors.Wrap(ctx.Err(), "error received from context")
+	case <-timer.C:
+		return true, nil
+	}
+}
+
+// RetryTier is the retry topic along with the delay the messages are
+// processed with
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+// RetryTopics produces failed messages to the next retry topic with
+// NotBeforeHeader set to the current time plus the tier delay. The tier is
+// selected by RetryAttemptHeader so the same policy could be used for the
+// main topic and the retry ones. Messages failed in the last tier are sent
+// to the dead letter queue or dropped if dlq is nil.
+//
+// Retry topics are expected to be consumed by the service created with
+// WithDelay(NotBeforeFromHeader(NotBeforeHeader)).
+// The session is restarted if the message cannot be produced.
+func RetryTopics(p producer.Producer, tiers []RetryTier, dlq DeadLetterQueue) ErrorPolicy {
+	return ErrorPolicyFunc(func(ctx context.Context, msg *sarama.ConsumerMessage, _ int, err error) Decision {
+		logger := log.WithError(err).WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+			"topic":     msg.Topic,
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+		})
+
+		var attempt int
+		if v, ok := producer.Header(msg, RetryAttemptHeader); ok {
+			attempt, _ = strconv.Atoi(v)
+		}
+		if attempt >= len(tiers) {
+			if dlq == nil {
+				logger.Error("retry topics exhausted. Dropping message ...")
+				return DecisionSkip
+			}
+
+			if dlqErr := dlq.Send(ctx, msg, err); dlqErr != nil {
+				logger.WithError(dlqErr).Error("error sending message to dead letter queue")
+				return DecisionRestart
+			}
+			return DecisionSkip
+		}
+
+		tier := tiers[attempt]
+
+		originalTopic, ok := producer.Header(msg, RetryTopicHeader)
+		if !ok {
+			originalTopic = msg.Topic
+		}
+
+		out := producer.NewMessage(tier.Topic, msg.Value,
+			copyHeaders(msg, NotBeforeHeader, RetryAttemptHeader, RetryTopicHeader, RetryErrorHeader)...)
+		if msg.Key != nil {
+			out.Key = sarama.ByteEncoder(msg.Key)
+		}
+
+		producer.WithHeaders(map[string]string{
+			NotBeforeHeader:    strconv.FormatInt(time.Now().Add(tier.Delay).UnixMilli(), 10),
+			RetryAttemptHeader: strconv.Itoa(attempt + 1),
+			RetryTopicHeader:   originalTopic,
+			RetryErrorHeader:   err.Error(),
+		})(out)
+
+		if produceErr := p.Produce(ctx, out); produceErr != nil {
+			logger.WithError(produceErr).WithFields(log.Fields{
+				"retry_topic": tier.Topic,
+			}).Error("error sending message to retry topic")
+			return DecisionRestart
+		}
+		return DecisionSkip
+	})
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+	"github.com/teran/go-collection/dataprocessing/kafka/producer"
+)
+
+func TestNotBeforeFromHeader(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+
+	tcs := []struct {
+		name     string
+		headers  []*sarama.RecordHeader
+		expected time.Time
+	}{
+		{
+			name: "valid header",
+			headers: []*sarama.RecordHeader{
+				{Key: []byte(NotBeforeHeader), Value: []byte(strconv.FormatInt(now.UnixMilli(), 10))},
+			},
+			expected: now,
+		},
+		{
+			name:     "no header",
+			expected: time.Time{},
+		},
+		{
+			name: "malformed header",
+			headers: []*sarama.RecordHeader{
+				{Key: []byte(NotBeforeHeader), Value: []byte("tomorrow")},
+			},
+			expected: time.Time{},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			fn := NotBeforeFromHeader(NotBeforeHeader)
+			r.True(tc.expected.Equal(fn(&sarama.ConsumerMessage{Headers: tc.headers})))
+		})
+	}
+}
+
+func TestDelayFromHeader(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	started := time.Now()
+	notBefore := started.Add(300 * time.Millisecond)
+
+	cg := fake.NewConsumerGroup()
+	cg.Produce("test-topic", 0, &sarama.ConsumerMessage{
+		Value: []byte("delayed"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(NotBeforeHeader), Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+		},
+	})
+	cg.ProduceValues("test-topic", 0, "immediate")
+
+	h := &recordingHandler{}
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h, WithDelay(NotBeforeFromHeader(NotBeforeHeader))))
+
+	r.Eventually(func() bool {
+		return cg.Paused("test-topic", 0)
+	}, time.Second, 10*time.Millisecond)
+	r.Empty(h.values())
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.GreaterOrEqual(time.Since(started), 290*time.Millisecond)
+	r.Equal([]string{"delayed", "immediate"}, h.values())
+	r.False(cg.Paused("test-topic", 0))
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestDelayFromTimestampKeepsManualPause(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "first", "second")
+
+	h := &recordingHandler{}
+	svc := New(cg, []string{"test-topic"}, h, WithDelay(NotBeforeFromTimestamp(200*time.Millisecond)))
+	errCh := runService(ctx, svc)
+
+	r.Eventually(func() bool {
+		return cg.Paused("test-topic", 0)
+	}, time.Second, 10*time.Millisecond)
+
+	svc.Pause(map[string][]int32{"test-topic": {0}})
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 1))
+	r.True(cg.Paused("test-topic", 0))
+	r.Equal([]string{"first"}, h.values())
+
+	svc.Resume(map[string][]int32{"test-topic": {0}})
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal([]string{"first", "second"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestRetryTopics(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	mp := mocks.NewSyncProducer(t, nil)
+	defer func() { r.NoError(mp.Close()) }()
+
+	started := time.Now()
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "test-topic-retry-5s" {
+			return errors.Errorf("unexpected topic: %s", msg.Topic)
+		}
+
+		headers := producerHeaders(msg)
+		for k, v := range map[string]string{
+			"x-trace-id":       "trace-1",
+			RetryAttemptHeader: "1",
+			RetryTopicHeader:   "test-topic",
+			RetryErrorHeader:   "blah",
+		} {
+			if headers[k] != v {
+				return errors.Errorf("unexpected header %s value: `%s`", k, headers[k])
+			}
+		}
+
+		ms, err := strconv.ParseInt(headers[NotBeforeHeader], 10, 64)
+		if err != nil {
+			return err
+		}
+		if time.UnixMilli(ms).Before(started.Add(5 * time.Second).Truncate(time.Millisecond)) {
+			return errors.Errorf("unexpected not-before value: %d", ms)
+		}
+		return nil
+	})
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "test-topic-dlq" {
+			return errors.Errorf("unexpected topic: %s", msg.Topic)
+		}
+
+		headers := producerHeaders(msg)
+		if headers[RetryTopicHeader] != "test-topic" {
+			return errors.Errorf("unexpected original topic: `%s`", headers[RetryTopicHeader])
+		}
+		return nil
+	})
+
+	p, err := producer.NewSync(mp)
+	r.NoError(err)
+
+	cg := fake.NewConsumerGroup()
+	cg.Produce("test-topic", 0,
+		&sarama.ConsumerMessage{
+			Value:   []byte("fail"),
+			Headers: []*sarama.RecordHeader{{Key: []byte("x-trace-id"), Value: []byte("trace-1")}},
+		},
+		&sarama.ConsumerMessage{
+			Value: []byte("fail"),
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte(RetryAttemptHeader), Value: []byte("2")},
+				{Key: []byte(RetryTopicHeader), Value: []byte("test-topic")},
+			},
+		},
+	)
+
+	h := &failingHandler{failures: map[string]int{"fail": 100}}
+	policy := RetryTopics(p, []RetryTier{
+		{Topic: "test-topic-retry-5s", Delay: 5 * time.Second},
+		{Topic: "test-topic-retry-1m", Delay: time.Minute},
+	}, NewDeadLetterQueue(p, "test-topic-dlq"))
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h, WithErrorPolicy(policy)))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal([]string{"fail", "fail"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func producerHeaders(msg *sarama.ProducerMessage) map[string]string {
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	return headers
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/producer"
+)
+
+var (
+	_ ErrorPolicy     = (ErrorPolicyFunc)(nil)
+	_ DeadLetterQueue = (*deadLetterQueue)(nil)
+)
+
+const (
+	DLQErrorHeader     = "x-dlq-error"
+	DLQTopicHeader     = "x-dlq-original-topic"
+	DLQPartitionHeader = "x-dlq-original-partition"
+	DLQOffsetHeader    = "x-dlq-original-offset"
+)
+
+// Decision is the outcome of the failed message handling
+type Decision int
+
+const (
+	// DecisionRestart leaves the message unmarked and restarts the session
+	// so the message is consumed again from the last committed offset
+	DecisionRestart Decision = iota
+	// DecisionStop stops the service: Run returns the handler error
+	DecisionStop
+	// DecisionSkip marks the message as processed and proceeds to the next one
+	DecisionSkip
+	// DecisionPause pauses the partition and retries the message once
+	// the partition is resumed
+	DecisionPause
+	// DecisionRetry runs the handler for the message once again
+	DecisionRetry
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionRestart:
+		return "restart"
+	case DecisionStop:
+		return "stop"
+	case DecisionSkip:
+		return "skip"
+	case DecisionPause:
+		return "pause"
+	case DecisionRetry:
+		return "retry"
+	default:
+		return "unknown(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// ErrorPolicy decides what to do with the message the handler failed to
+// process. Attempt is the number of handler runs for the message so far
+// starting from 1.
+//
+// ErrMarkAcked returned by the handler always results in DecisionSkip.
+type ErrorPolicy interface {
+	OnError(ctx context.Context, msg *sarama.ConsumerMessage, attempt int, err error) Decision
+}
+
+type ErrorPolicyFunc func(ctx context.Context, msg *sarama.ConsumerMessage, attempt int, err error) Decision
+
+func (f ErrorPolicyFunc) OnError(ctx context.Context, msg *sarama.ConsumerMessage, attempt int, err error) Decision {
+	return f(ctx, msg, attempt, err)
+}
+
+// RestartSession is the default policy
+func RestartSession() ErrorPolicy {
+	return decisionPolicy(DecisionRestart)
+}
+
+func StopService() ErrorPolicy {
+	return decisionPolicy(DecisionStop)
+}
+
+func SkipAndMark() ErrorPolicy {
+	return decisionPolicy(DecisionSkip)
+}
+
+// PausePartition pauses the partition until it's resumed with
+// Service.Resume or Service.ResumeAll
+func PausePartition() ErrorPolicy {
+	return decisionPolicy(DecisionPause)
+}
+
+func decisionPolicy(d Decision) ErrorPolicy {
+	return ErrorPolicyFunc(func(context.Context, *sarama.ConsumerMessage, int, error) Decision {
+		return d
+	})
+}
+
+// DeadLetterQueue stores messages failed to be processed
+type DeadLetterQueue interface {
+	Send(ctx context.Context, msg *sarama.ConsumerMessage, err error) error
+}
+
+type deadLetterQueue struct {
+	producer producer.Producer
+	topic    string
+}
+
+// NewDeadLetterQueue creates DeadLetterQueue producing failed messages to
+// the topic with their key, value and headers along with the headers
+// describing the error and the origin of the message
+func NewDeadLetterQueue(p producer.Producer, topic string) DeadLetterQueue {
+	return &deadLetterQueue{
+		producer: p,
+		topic:    topic,
+	}
+}
+
+func (q *deadLetterQueue) Send(ctx context.Context, msg *sarama.ConsumerMessage, err error) error {
+	out := producer.NewMessage(q.topic, msg.Value, copyHeaders(msg)...)
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	producer.WithHeaders(map[string]string{
+		DLQErrorHeader:     err.Error(),
+		DLQTopicHeader:     msg.Topic,
+		DLQPartitionHeader: strconv.FormatInt(int64(msg.Partition), 10),
+		DLQOffsetHeader:    strconv.FormatInt(msg.Offset, 10),
+	})(out)
+
+	return q.producer.Produce(ctx, out)
+}
+
+// copyHeaders returns options copying message headers except the excluded ones
+func copyHeaders(msg *sarama.ConsumerMessage, exclude ...string) []producer.MessageOption {
+	opts := make([]producer.MessageOption, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h != nil && !slices.Contains(exclude, string(h.Key)) {
+			opts = append(opts, producer.WithHeader(string(h.Key), string(h.Value)))
+		}
+	}
+	return opts
+}
+
+// RetryThenDLQ retries the message up to attempts times waiting for backoff
+// between the attempts and sends it to the dead letter queue after that.
+// The session is restarted if the message cannot be sent to the queue.
+// The message is dropped if dlq is nil.
+func RetryThenDLQ(attempts int, backoff time.Duration, dlq DeadLetterQueue) (ErrorPolicy, error) {
+	if attempts < 1 {
+		return nil, errors.Errorf("attempts must be positive, got %d", attempts)
+	}
+
+	return ErrorPolicyFunc(func(ctx context.Context, msg *sarama.ConsumerMessage, attempt int, err error) Decision {
+		if attempt < attempts {
+			select {
+			case <-ctx.Done():
+				return DecisionRestart
+			case <-time.After(backoff):
+				return DecisionRetry
+			}
+		}
+
+		logger := log.WithError(err).WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+			"topic":     msg.Topic,
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+		})
+
+		if dlq == nil {
+			logger.Error("retry attempts exhausted. Dropping message ...")
+			return DecisionSkip
+		}
+
+		if dlqErr := dlq.Send(ctx, msg, err); dlqErr != nil {
+			logger.WithError(dlqErr).Error("error sending message to dead letter queue")
+			return DecisionRestart
+		}
+
+		return DecisionSkip
+	}), nil
+}
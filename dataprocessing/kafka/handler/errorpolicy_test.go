@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+	"github.com/teran/go-collection/dataprocessing/kafka/producer"
+)
+
+func TestErrorPolicySkipAndMark(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "fail", "ok")
+
+	h := &failingHandler{failures: map[string]int{"fail": 100}}
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h, WithErrorPolicy(SkipAndMark())))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal(int32(1), cg.Generation())
+	r.Equal([]string{"fail", "ok"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestErrorPolicyStopService(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "skip", "fail", "ok")
+
+	h := &failingHandler{
+		failures: map[string]int{"fail": 100},
+		errs:     map[string]error{"skip": ErrMarkAcked},
+	}
+	err := New(cg, []string{"test-topic"}, h, WithErrorPolicy(StopService())).Run(ctx)
+	r.ErrorContains(err, "service stopped by error policy")
+	r.ErrorContains(err, "blah")
+
+	r.Equal([]string{"skip", "fail"}, h.values())
+	r.Equal(int64(1), cg.Committed("test-topic", 0))
+}
+
+func TestErrorPolicyRestartSession(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "fail", "ok")
+
+	h := &failingHandler{failures: map[string]int{"fail": 1}}
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal(int32(2), cg.Generation())
+	r.Equal([]string{"fail", "fail", "ok"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestErrorPolicyPausePartition(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "fail", "ok")
+
+	h := &failingHandler{failures: map[string]int{"fail": 1}}
+	svc := New(cg, []string{"test-topic"}, h, WithErrorPolicy(PausePartition()))
+	errCh := runService(ctx, svc)
+
+	r.Eventually(func() bool {
+		return cg.Paused("test-topic", 0)
+	}, time.Second, 10*time.Millisecond)
+	r.Equal([]string{"fail"}, h.values())
+
+	svc.Resume(map[string][]int32{"test-topic": {0}})
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal(int32(1), cg.Generation())
+	r.Equal([]string{"fail", "fail", "ok"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestErrorPolicyRetryThenDLQ(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	mp := mocks.NewSyncProducer(t, nil)
+	defer func() { r.NoError(mp.Close()) }()
+
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "test-topic-dlq" {
+			return errors.Errorf("unexpected topic: %s", msg.Topic)
+		}
+
+		headers := map[string]string{}
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+
+		expected := map[string]string{
+			"x-trace-id":       "trace-1",
+			DLQErrorHeader:     "blah",
+			DLQTopicHeader:     "test-topic",
+			DLQPartitionHeader: "0",
+			DLQOffsetHeader:    "0",
+		}
+		for k, v := range expected {
+			if headers[k] != v {
+				return errors.Errorf("unexpected header %s value: `%s`", k, headers[k])
+			}
+		}
+		return nil
+	})
+
+	p, err := producer.NewSync(mp)
+	r.NoError(err)
+
+	cg := fake.NewConsumerGroup()
+	cg.Produce("test-topic", 0, &sarama.ConsumerMessage{
+		Value:   []byte("fail"),
+		Headers: []*sarama.RecordHeader{{Key: []byte("x-trace-id"), Value: []byte("trace-1")}},
+	})
+	cg.ProduceValues("test-topic", 0, "ok")
+
+	h := &failingHandler{failures: map[string]int{"fail": 100}}
+	policy, err := RetryThenDLQ(3, time.Millisecond, NewDeadLetterQueue(p, "test-topic-dlq"))
+	r.NoError(err)
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h, WithErrorPolicy(policy)))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal([]string{"fail", "fail", "fail", "ok"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestErrorPolicyRetryThenDLQWithoutQueue(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "fail", "ok")
+
+	h := &failingHandler{failures: map[string]int{"fail": 100}}
+	policy, err := RetryThenDLQ(2, time.Millisecond, nil)
+	r.NoError(err)
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h, WithErrorPolicy(policy)))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.Equal([]string{"fail", "fail", "ok"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+
+	for _, attempts := range []int{0, -1} {
+		_, err = RetryThenDLQ(attempts, time.Millisecond, nil)
+		r.Error(err)
+	}
+}
+
+type failingHandler struct {
+	mutex    sync.Mutex
+	failures map[string]int
+	errs     map[string]error
+	handled  []string
+}
+
+func (h *failingHandler) Handle(_ context.Context, msg *sarama.ConsumerMessage) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	v := string(msg.Value)
+	h.handled = append(h.handled, v)
+
+	if err, ok := h.errs[v]; ok {
+		return err
+	}
+
+	if h.failures[v] > 0 {
+		h.failures[v]--
+		return errors.New("blah")
+	}
+	return nil
+}
+
+func (h *failingHandler) values() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]string{}, h.handled...)
+}
@@ -0,0 +1,495 @@
+// Package fake implements in-memory sarama.ConsumerGroup to test consumer
+// group handlers deterministically without running Kafka
+package fake
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var _ sarama.ConsumerGroup = (*ConsumerGroup)(nil)
+
+type partitionLog struct {
+	// messages are nil for control records
+	messages     []*sarama.ConsumerMessage
+	committed    int64
+	hasCommitted bool
+}
+
+// ConsumerGroup is in-memory consumer group with the single member.
+//
+// Each Consume call runs the session the same way sarama does: Setup is
+// called, then ConsumeClaim per claimed partition, the session ends as soon
+// as any ConsumeClaim returns, the context is cancelled or Rebalance is
+// called, then Cleanup is called and marked offsets are committed.
+// Consumption of each partition starts from the committed offset.
+type ConsumerGroup struct {
+	mutex   sync.Mutex
+	changed chan struct{}
+
+	logs          map[string]map[int32]*partitionLog
+	assignment    map[string][]int32
+	paused        map[string]map[int32]bool
+	session       *session
+	initialOffset int64
+	generation    int32
+	commits       int
+	closed        bool
+	errors        chan error
+}
+
+func NewConsumerGroup() *ConsumerGroup {
+	return &ConsumerGroup{
+		changed: make(chan struct{}),
+		logs:    map[string]map[int32]*partitionLog{},
+		paused:  map[string]map[int32]bool{},
+		errors:  make(chan error, 256),
+	}
+}
+
+// AddTopic creates topic partitions so they're claimed even without
+// any messages produced
+func (g *ConsumerGroup) AddTopic(topic string, partitions int32) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for p := range partitions {
+		g.partitionLocked(topic, p)
+	}
+	g.notifyLocked()
+}
+
+// Produce appends messages to the partition. Topic, partition and offset
+// are populated automatically, timestamp is set to the current time when
+// not provided.
+func (g *ConsumerGroup) Produce(topic string, partition int32, msgs ...*sarama.ConsumerMessage) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	l := g.partitionLocked(topic, partition)
+	for _, msg := range msgs {
+		msg.Topic = topic
+		msg.Partition = partition
+		msg.Offset = int64(len(l.messages))
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = time.Now()
+		}
+
+		l.messages = append(l.messages, msg)
+	}
+	g.notifyLocked()
+}
+
+// ProduceControlRecord appends transaction control record to the partition.
+// It takes the offset but it's never delivered to consumers the same way
+// Kafka does.
+func (g *ConsumerGroup) ProduceControlRecord(topic string, partition int32) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	l := g.partitionLocked(topic, partition)
+	l.messages = append(l.messages, nil)
+	g.notifyLocked()
+}
+
+// ProduceValues is the shortcut for Produce with the messages having
+// the values only
+func (g *ConsumerGroup) ProduceValues(topic string, partition int32, values ...string) {
+	msgs := make([]*sarama.ConsumerMessage, len(values))
+	for i, v := range values {
+		msgs[i] = &sarama.ConsumerMessage{Value: []byte(v)}
+	}
+	g.Produce(topic, partition, msgs...)
+}
+
+// Assign sets partitions claimed by the next sessions. By default all
+// the partitions of the subscribed topics are claimed.
+func (g *ConsumerGroup) Assign(claims map[string][]int32) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.assignment = claims
+}
+
+// SetInitialOffset sets the offset claims of the partitions without
+// committed offset start from: sarama.OffsetOldest or sarama.OffsetNewest.
+// Claims report it as InitialOffset the same way sarama does with
+// Config.Consumer.Offsets.Initial. By default such claims start from 0.
+func (g *ConsumerGroup) SetInitialOffset(offset int64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.initialOffset = offset
+}
+
+// Rebalance ends the current session so the next Consume call starts
+// the new one with the next generation
+func (g *ConsumerGroup) Rebalance() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.session != nil {
+		g.session.cancelFn()
+	}
+}
+
+// CloseMessages closes message channels of the current session claims
+// without ending the session
+func (g *ConsumerGroup) CloseMessages() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.session == nil {
+		return
+	}
+
+	for _, c := range g.session.claimsList {
+		c.closeOnce.Do(func() { close(c.closeCh) })
+	}
+	g.notifyLocked()
+}
+
+// SendError passes the error to Errors channel
+func (g *ConsumerGroup) SendError(err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.closed {
+		return
+	}
+
+	select {
+	case g.errors <- err:
+	default:
+	}
+}
+
+// Committed returns the committed offset of the partition
+func (g *ConsumerGroup) Committed(topic string, partition int32) int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if l, ok := g.logs[topic][partition]; ok {
+		return l.committed
+	}
+	return 0
+}
+
+// Marked returns the offset marked in the current session or the
+// committed offset if there's no session
+func (g *ConsumerGroup) Marked(topic string, partition int32) int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.session != nil {
+		if o, ok := g.session.marked[topic][partition]; ok {
+			return o
+		}
+	}
+
+	if l, ok := g.logs[topic][partition]; ok {
+		return l.committed
+	}
+	return 0
+}
+
+// Commits returns the amount of Commit calls
+func (g *ConsumerGroup) Commits() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.commits
+}
+
+// Generation returns the generation of the latest session
+func (g *ConsumerGroup) Generation() int32 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.generation
+}
+
+// Paused reports whether the partition of the current session is paused
+func (g *ConsumerGroup) Paused(topic string, partition int32) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.paused[topic][partition]
+}
+
+// WaitCommitted blocks until the committed offset of the partition reaches
+// the offset or the context is done
+func (g *ConsumerGroup) WaitCommitted(ctx context.Context, topic string, partition int32, offset int64) error {
+	return g.wait(ctx, func() bool {
+		l, ok := g.logs[topic][partition]
+		return ok && l.committed >= offset
+	})
+}
+
+// WaitGeneration blocks until the session of the generation or newer is
+// started and its claims are being consumed or the context is done
+func (g *ConsumerGroup) WaitGeneration(ctx context.Context, generation int32) error {
+	return g.wait(ctx, func() bool {
+		return g.session != nil && g.session.started && g.generation >= generation
+	})
+}
+
+// GetOffset resolves the partition offset the same way sarama.Client does:
+// sarama.OffsetOldest, sarama.OffsetNewest or the offset of the first
+// message with the timestamp (in milliseconds) equal or later than time,
+// -1 if there's no such message
+func (g *ConsumerGroup) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	l, ok := g.logs[topic][partition]
+	if !ok {
+		return 0, sarama.ErrUnknownTopicOrPartition
+	}
+
+	switch time {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(l.messages)), nil
+	}
+
+	for _, msg := range l.messages {
+		if msg != nil && msg.Timestamp.UnixMilli() >= time {
+			return msg.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+func (g *ConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.mutex.Lock()
+	if g.closed {
+		g.mutex.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	g.generation++
+	sess := newSession(ctx, g, g.generation, g.claimsLocked(topics))
+	g.session = sess
+	g.paused = map[string]map[int32]bool{}
+	g.notifyLocked()
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		if g.session == sess {
+			g.session = nil
+		}
+		g.notifyLocked()
+	}()
+
+	if err := handler.Setup(sess); err != nil {
+		sess.cancelFn()
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+
+	g.mutex.Lock()
+	for _, c := range sess.newClaims() {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			c.feed(sess.ctx)
+		}()
+
+		go func() {
+			defer wg.Done()
+			// the same as sarama does: session ends once any claim is over
+			defer sess.cancelFn()
+
+			if err := handler.ConsumeClaim(sess, c); err != nil {
+				g.SendError(err)
+			}
+		}()
+	}
+	sess.started = true
+	g.notifyLocked()
+	g.mutex.Unlock()
+
+	<-sess.ctx.Done()
+	wg.Wait()
+
+	if err := handler.Cleanup(sess); err != nil {
+		return err
+	}
+	sess.Commit()
+
+	return nil
+}
+
+func (g *ConsumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *ConsumerGroup) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.closed {
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	g.closed = true
+	if g.session != nil {
+		g.session.cancelFn()
+	}
+	close(g.errors)
+	g.notifyLocked()
+
+	return nil
+}
+
+func (g *ConsumerGroup) Pause(partitions map[string][]int32) {
+	g.setPaused(partitions, true)
+}
+
+func (g *ConsumerGroup) Resume(partitions map[string][]int32) {
+	g.setPaused(partitions, false)
+}
+
+func (g *ConsumerGroup) PauseAll() {
+	g.setAllPaused(true)
+}
+
+func (g *ConsumerGroup) ResumeAll() {
+	g.setAllPaused(false)
+}
+
+func (g *ConsumerGroup) setAllPaused(paused bool) {
+	g.mutex.Lock()
+	sess := g.session
+	g.mutex.Unlock()
+
+	if sess != nil {
+		g.setPaused(sess.claims, paused)
+	}
+}
+
+// setPaused changes the state of the partitions claimed by the current
+// session only since sarama pauses existing partition consumers only
+func (g *ConsumerGroup) setPaused(partitions map[string][]int32, paused bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.session == nil {
+		return
+	}
+
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			if !g.session.isClaimed(topic, p) {
+				continue
+			}
+
+			if _, ok := g.paused[topic]; !ok {
+				g.paused[topic] = map[int32]bool{}
+			}
+			g.paused[topic][p] = paused
+		}
+	}
+	g.notifyLocked()
+}
+
+func (g *ConsumerGroup) partitionLocked(topic string, partition int32) *partitionLog {
+	if _, ok := g.logs[topic]; !ok {
+		g.logs[topic] = map[int32]*partitionLog{}
+	}
+
+	l, ok := g.logs[topic][partition]
+	if !ok {
+		l = &partitionLog{}
+		g.logs[topic][partition] = l
+	}
+	return l
+}
+
+func (g *ConsumerGroup) claimsLocked(topics []string) map[string][]int32 {
+	claims := map[string][]int32{}
+	for _, topic := range topics {
+		if g.assignment != nil {
+			if ps, ok := g.assignment[topic]; ok {
+				claims[topic] = append([]int32{}, ps...)
+			}
+			continue
+		}
+
+		for p := range g.logs[topic] {
+			claims[topic] = append(claims[topic], p)
+		}
+		sort.Slice(claims[topic], func(i, j int) bool { return claims[topic][i] < claims[topic][j] })
+	}
+	return claims
+}
+
+// notifyLocked wakes up everyone waiting for the state change
+func (g *ConsumerGroup) notifyLocked() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *ConsumerGroup) wait(ctx context.Context, cond func() bool) error {
+	for {
+		g.mutex.Lock()
+		ok := cond()
+		changed := g.changed
+		g.mutex.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// next returns the first message at the offset or later skipping control
+// records if it's available and the partition is not paused along with
+// the channel to wait for changes
+func (g *ConsumerGroup) next(topic string, partition int32, offset int64) (*sarama.ConsumerMessage, <-chan struct{}) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.paused[topic][partition] {
+		return nil, g.changed
+	}
+
+	l, ok := g.logs[topic][partition]
+	if !ok {
+		return nil, g.changed
+	}
+
+	for ; offset < int64(len(l.messages)); offset++ {
+		if l.messages[offset] != nil {
+			return l.messages[offset], g.changed
+		}
+	}
+	return nil, g.changed
+}
+
+func (g *ConsumerGroup) highWaterMark(topic string, partition int32) int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if l, ok := g.logs[topic][partition]; ok {
+		return int64(len(l.messages))
+	}
+	return 0
+}
@@ -0,0 +1,297 @@
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerGroup(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+	cg.ProduceValues("test-topic", 1, "d")
+
+	h := &testHandler{values: make(chan string, 10)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 3))
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 1, 1))
+	r.Equal(int32(1), cg.Generation())
+	r.Equal(map[string][]int32{"test-topic": {0, 1}}, h.claims)
+
+	cg.Rebalance()
+	r.NoError(<-errCh)
+	r.True(h.cleanedUp)
+
+	// replay partition #0 from offset 1 in the next session
+	h.resetOffset = map[int32]int64{0: 1}
+	cg.Assign(map[string][]int32{"test-topic": {0}})
+
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+
+	r.NoError(cg.WaitGeneration(ctx, 2))
+	r.Equal(map[string][]int32{"test-topic": {0}}, h.claims)
+
+	values := []string{}
+	for range 6 {
+		values = append(values, <-h.values)
+	}
+	r.ElementsMatch([]string{"a", "b", "c", "d", "b", "c"}, values)
+
+	r.NoError(cg.Close())
+	r.NoError(<-errCh)
+	r.ErrorIs(cg.Consume(ctx, []string{"test-topic"}, h), sarama.ErrClosedConsumerGroup)
+}
+
+func TestConsumerGroupPause(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := NewConsumerGroup()
+	cg.AddTopic("test-topic", 1)
+
+	// no session: nothing to pause
+	cg.PauseAll()
+	r.False(cg.Paused("test-topic", 0))
+
+	h := &testHandler{values: make(chan string, 10)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	cg.PauseAll()
+	r.True(cg.Paused("test-topic", 0))
+
+	cg.ProduceValues("test-topic", 0, "a")
+	select {
+	case v := <-h.values:
+		r.Failf("unexpected message", "message `%s` consumed from paused partition", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cg.ResumeAll()
+	r.Equal("a", <-h.values)
+
+	cg.CloseMessages()
+	r.NoError(<-errCh)
+	r.Equal(int64(1), cg.Committed("test-topic", 0))
+}
+
+func TestConsumerGroupPauseBuffered(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b")
+
+	h := &testHandler{
+		values:  make(chan string, 10),
+		claimCh: make(chan sarama.ConsumerGroupClaim, 1),
+		gate:    make(chan struct{}),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+
+	claim := <-h.claimCh
+	r.Eventually(func() bool {
+		return len(claim.Messages()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// messages fetched before the pause are still delivered
+	cg.PauseAll()
+	cg.ProduceValues("test-topic", 0, "c")
+	close(h.gate)
+
+	r.Equal("a", <-h.values)
+	r.Equal("b", <-h.values)
+	select {
+	case v := <-h.values:
+		r.Failf("unexpected message", "message `%s` consumed from paused partition", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cg.ResumeAll()
+	r.Equal("c", <-h.values)
+
+	cg.CloseMessages()
+	r.NoError(<-errCh)
+}
+
+func TestConsumerGroupResetOffset(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+
+	h := &testHandler{values: make(chan string, 10)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 3))
+	r.NoError(cg.Close())
+	r.NoError(<-errCh)
+
+	sess := newSession(ctx, cg, 1, map[string][]int32{"test-topic": {0}})
+
+	// the same as sarama: ResetOffset moves the offset backward only
+	sess.ResetOffset("test-topic", 0, 5, "")
+	r.Equal(int64(3), sess.marked["test-topic"][0])
+
+	sess.ResetOffset("test-topic", 0, 1, "")
+	r.Equal(int64(1), sess.marked["test-topic"][0])
+
+	sess.MarkOffset("test-topic", 0, 0, "")
+	r.Equal(int64(1), sess.marked["test-topic"][0])
+
+	sess.MarkOffset("test-topic", 0, 2, "")
+	r.Equal(int64(2), sess.marked["test-topic"][0])
+}
+
+func TestConsumerGroupInitialOffsetAndControlRecords(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := NewConsumerGroup()
+	cg.SetInitialOffset(sarama.OffsetNewest)
+	cg.ProduceValues("test-topic", 0, "a")
+	cg.ProduceControlRecord("test-topic", 0)
+
+	h := &testHandler{
+		values:  make(chan string, 10),
+		claimCh: make(chan sarama.ConsumerGroupClaim, 1),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cg.Consume(ctx, []string{"test-topic"}, h)
+	}()
+
+	claim := <-h.claimCh
+	r.Equal(sarama.OffsetNewest, claim.InitialOffset())
+	r.Equal(int64(2), claim.HighWaterMarkOffset())
+
+	// control records are never delivered
+	cg.ProduceValues("test-topic", 0, "b")
+	cg.ProduceControlRecord("test-topic", 0)
+	cg.ProduceValues("test-topic", 0, "c")
+	r.Equal("b", <-h.values)
+	r.Equal("c", <-h.values)
+	r.Equal(int64(5), claim.HighWaterMarkOffset())
+
+	cg.CloseMessages()
+	r.NoError(<-errCh)
+	r.Equal(int64(5), cg.Committed("test-topic", 0))
+
+	offset, err := cg.GetOffset("test-topic", 0, time.Now().Add(-time.Hour).UnixMilli())
+	r.NoError(err)
+	r.Equal(int64(0), offset)
+}
+
+type testHandler struct {
+	claims      map[string][]int32
+	cleanedUp   bool
+	resetOffset map[int32]int64
+	values      chan string
+
+	// claimCh receives the claim and gate blocks its consumption if set
+	claimCh chan sarama.ConsumerGroupClaim
+	gate    chan struct{}
+}
+
+func (h *testHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	h.claims = sess.Claims()
+	for p, o := range h.resetOffset {
+		sess.ResetOffset("test-topic", p, o, "")
+	}
+	return nil
+}
+
+func (h *testHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	h.cleanedUp = true
+	return nil
+}
+
+func (h *testHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if h.claimCh != nil {
+		h.claimCh <- claim
+	}
+	if h.gate != nil {
+		<-h.gate
+	}
+
+	for msg := range claim.Messages() {
+		h.values <- string(msg.Value)
+		sess.MarkMessage(msg, "")
+		sess.Commit()
+	}
+	return nil
+}
+
+func TestConsumerGroupGetOffset(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Now()
+
+	cg := NewConsumerGroup()
+	cg.Produce("test-topic", 0,
+		&sarama.ConsumerMessage{Value: []byte("a"), Timestamp: now.Add(-2 * time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("b"), Timestamp: now.Add(-time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("c"), Timestamp: now},
+	)
+
+	tcs := []struct {
+		name     string
+		time     int64
+		expected int64
+	}{
+		{name: "oldest", time: sarama.OffsetOldest, expected: 0},
+		{name: "newest", time: sarama.OffsetNewest, expected: 3},
+		{name: "exact timestamp", time: now.Add(-time.Hour).UnixMilli(), expected: 1},
+		{name: "between timestamps", time: now.Add(-90 * time.Minute).UnixMilli(), expected: 1},
+		{name: "after last message", time: now.Add(time.Hour).UnixMilli(), expected: -1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			offset, err := cg.GetOffset("test-topic", 0, tc.time)
+			r.NoError(err)
+			r.Equal(tc.expected, offset)
+		})
+	}
+
+	_, err := cg.GetOffset("unknown-topic", 0, sarama.OffsetNewest)
+	r.ErrorIs(err, sarama.ErrUnknownTopicOrPartition)
+}
@@ -0,0 +1,220 @@
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// channelBufferSize is the size of claim message channels, the same as
+// sarama's default Config.ChannelBufferSize
+const channelBufferSize = 256
+
+var (
+	_ sarama.ConsumerGroupSession = (*session)(nil)
+	_ sarama.ConsumerGroupClaim   = (*claim)(nil)
+)
+
+type session struct {
+	group      *ConsumerGroup
+	ctx        context.Context
+	cancelFn   context.CancelFunc
+	generation int32
+	claims     map[string][]int32
+	claimsList []*claim
+	started    bool
+
+	// marked is protected by the group mutex
+	marked map[string]map[int32]int64
+}
+
+func newSession(ctx context.Context, g *ConsumerGroup, generation int32, claims map[string][]int32) *session {
+	ctx, cancelFn := context.WithCancel(ctx)
+
+	marked := map[string]map[int32]int64{}
+	for topic, ps := range claims {
+		marked[topic] = map[int32]int64{}
+		for _, p := range ps {
+			l := g.partitionLocked(topic, p)
+
+			marked[topic][p] = l.committed
+			if !l.hasCommitted && g.initialOffset < 0 {
+				marked[topic][p] = g.initialOffset
+			}
+		}
+	}
+
+	return &session{
+		group:      g,
+		ctx:        ctx,
+		cancelFn:   cancelFn,
+		generation: generation,
+		claims:     claims,
+		marked:     marked,
+	}
+}
+
+// newClaims must be called with the group mutex held
+func (s *session) newClaims() []*claim {
+	for topic, ps := range s.claims {
+		for _, p := range ps {
+			// the initial offset is resolved once the claim is created
+			// like sarama does before ConsumeClaim is called
+			offset := s.marked[topic][p]
+			switch offset {
+			case sarama.OffsetOldest:
+				offset = 0
+			case sarama.OffsetNewest:
+				offset = int64(len(s.group.partitionLocked(topic, p).messages))
+			}
+
+			s.claimsList = append(s.claimsList, &claim{
+				group:         s.group,
+				topic:         topic,
+				partition:     p,
+				initialOffset: s.marked[topic][p],
+				offset:        offset,
+				messages:      make(chan *sarama.ConsumerMessage, channelBufferSize),
+				closeCh:       make(chan struct{}),
+			})
+		}
+	}
+	return s.claimsList
+}
+
+func (s *session) isClaimed(topic string, partition int32) bool {
+	_, ok := s.marked[topic][partition]
+	return ok
+}
+
+func (s *session) Claims() map[string][]int32 {
+	out := make(map[string][]int32, len(s.claims))
+	for topic, ps := range s.claims {
+		out[topic] = append([]int32{}, ps...)
+	}
+	return out
+}
+
+func (s *session) MemberID() string {
+	return "fake-member"
+}
+
+func (s *session) GenerationID() int32 {
+	return s.generation
+}
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.group.mutex.Lock()
+	defer s.group.mutex.Unlock()
+
+	if cur, ok := s.marked[topic][partition]; ok && offset > cur {
+		s.marked[topic][partition] = offset
+		s.group.notifyLocked()
+	}
+}
+
+// ResetOffset moves the marked offset backward only the same way sarama
+// does, MarkOffset is to be used to move it forward
+func (s *session) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.group.mutex.Lock()
+	defer s.group.mutex.Unlock()
+
+	if cur, ok := s.marked[topic][partition]; ok && offset <= cur {
+		s.marked[topic][partition] = offset
+		s.group.notifyLocked()
+	}
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *session) Commit() {
+	s.group.mutex.Lock()
+	defer s.group.mutex.Unlock()
+
+	for topic, ps := range s.marked {
+		for p, offset := range ps {
+			// nothing is marked yet for the claim started from initial offset
+			if offset < 0 {
+				continue
+			}
+
+			l := s.group.partitionLocked(topic, p)
+			l.committed = offset
+			l.hasCommitted = true
+		}
+	}
+	s.group.commits++
+	s.group.notifyLocked()
+}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+type claim struct {
+	group         *ConsumerGroup
+	topic         string
+	partition     int32
+	initialOffset int64
+	offset        int64
+	messages      chan *sarama.ConsumerMessage
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return c.partition
+}
+
+func (c *claim) InitialOffset() int64 {
+	return c.initialOffset
+}
+
+func (c *claim) HighWaterMarkOffset() int64 {
+	return c.group.highWaterMark(c.topic, c.partition)
+}
+
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// feed delivers partition messages to the buffered claim channel until
+// the session is over or CloseMessages is called. Pause stops feeding only:
+// messages already buffered are still delivered like in sarama.
+func (c *claim) feed(ctx context.Context) {
+	defer close(c.messages)
+
+	offset := c.offset
+	for {
+		msg, changed := c.group.next(c.topic, c.partition, offset)
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.closeCh:
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.closeCh:
+			return
+		case <-changed:
+			// partition could be paused in the meantime
+		case c.messages <- msg:
+			offset = msg.Offset + 1
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// flowControl keeps the pause state and rate limits of the service.
+//
+// sarama applies Pause/Resume to existing partition consumers only so the
+// state is tracked here and reapplied to the claims of every new session.
+type flowControl struct {
+	cg sarama.ConsumerGroup
+
+	mutex      sync.Mutex
+	paused     map[string]map[int32]struct{}
+	resumed    map[string]map[int32]struct{}
+	pauseAll   bool
+	autoPaused bool
+	// held are partitions paused temporarily by hold, they stay paused
+	// regardless of resumes until released
+	held map[partitionKey]int
+	// changed is closed and replaced on every resume
+	changed chan struct{}
+
+	messageLimiter *rate.Limiter
+	byteLimiter    *rate.Limiter
+}
+
+func newFlowControl(cg sarama.ConsumerGroup) *flowControl {
+	return &flowControl{
+		cg:      cg,
+		paused:  map[string]map[int32]struct{}{},
+		resumed: map[string]map[int32]struct{}{},
+		held:    map[partitionKey]int{},
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *flowControl) Pause(partitions map[string][]int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	addPartitions(f.paused, partitions)
+	removePartitions(f.resumed, partitions)
+	f.cg.Pause(partitions)
+}
+
+func (f *flowControl) Resume(partitions map[string][]int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	removePartitions(f.paused, partitions)
+	if f.pauseAll {
+		addPartitions(f.resumed, partitions)
+	}
+
+	if !f.autoPaused {
+		f.cg.Resume(partitions)
+		f.pauseHeldLocked()
+	}
+	f.notifyLocked()
+}
+
+func (f *flowControl) PauseAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pauseAll = true
+	f.resumed = map[string]map[int32]struct{}{}
+	f.cg.PauseAll()
+}
+
+func (f *flowControl) ResumeAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pauseAll = false
+	f.paused = map[string]map[int32]struct{}{}
+	f.resumed = map[string]map[int32]struct{}{}
+
+	if !f.autoPaused {
+		f.cg.ResumeAll()
+		f.pauseHeldLocked()
+	}
+	f.notifyLocked()
+}
+
+func (f *flowControl) isPaused(topic string, partition int32) bool {
+	if f.autoPaused {
+		return true
+	}
+
+	if f.pauseAll {
+		_, ok := f.resumed[topic][partition]
+		return !ok
+	}
+
+	_, ok := f.paused[topic][partition]
+	return ok
+}
+
+// isHeldOrPaused reports whether the partition is paused for any reason including
+// holds
+func (f *flowControl) isHeldOrPaused(topic string, partition int32) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.isPaused(topic, partition) || f.held[partitionKey{topic, partition}] > 0
+}
+
+// apply pauses the claimed partition if it was requested to be paused
+// before the claim was started
+func (f *flowControl) apply(topic string, partition int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.isPaused(topic, partition) {
+		f.cg.Pause(map[string][]int32{topic: {partition}})
+	}
+}
+
+// pauseAndWait pauses the partition and blocks until it's resumed
+func (f *flowControl) pauseAndWait(ctx context.Context, topic string, partition int32) error {
+	f.Pause(map[string][]int32{topic: {partition}})
+
+	_, err := f.waitResumed(ctx, nil, topic, partition)
+	return err
+}
+
+// waitResumed blocks while the partition is paused. sarama Pause stops
+// fetching only so messages already fetched are still delivered to the
+// claim and must not be processed until the partition is resumed. It
+// returns false if stopCh is closed in the meantime.
+func (f *flowControl) waitResumed(ctx context.Context, stopCh <-chan struct{}, topic string, partition int32) (bool, error) {
+	for {
+		f.mutex.Lock()
+		paused := f.isPaused(topic, partition)
+		changed := f.changed
+		f.mutex.Unlock()
+
+		if !paused {
+			return true, nil
+		}
+
+		select {
+		case <-stopCh:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// hold pauses the partition temporarily without changing its pause state
+func (f *flowControl) hold(topic string, partition int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.held[partitionKey{topic, partition}]++
+	f.cg.Pause(map[string][]int32{topic: {partition}})
+}
+
+// release resumes the partition paused by hold unless it's paused
+// for any other reason
+func (f *flowControl) release(topic string, partition int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	k := partitionKey{topic, partition}
+	f.held[k]--
+	if f.held[k] > 0 {
+		return
+	}
+	delete(f.held, k)
+
+	if !f.isPaused(topic, partition) {
+		f.cg.Resume(map[string][]int32{topic: {partition}})
+	}
+}
+
+// pauseHeldLocked reapplies holds after partitions were resumed
+func (f *flowControl) pauseHeldLocked() {
+	if len(f.held) == 0 {
+		return
+	}
+
+	partitions := map[string][]int32{}
+	for k := range f.held {
+		partitions[k.topic] = append(partitions[k.topic], k.partition)
+	}
+	f.cg.Pause(partitions)
+}
+
+func (f *flowControl) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *flowControl) setHealthy(healthy bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch {
+	case !healthy && !f.autoPaused:
+		log.WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+		}).Warn("health check failed. Pausing consumption ...")
+
+		f.autoPaused = true
+		f.cg.PauseAll()
+	case healthy && f.autoPaused:
+		log.WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+		}).Info("health check recovered. Resuming consumption ...")
+
+		f.autoPaused = false
+		defer f.notifyLocked()
+
+		if f.pauseAll {
+			f.cg.Resume(toPartitionsMap(f.resumed))
+			f.pauseHeldLocked()
+			return
+		}
+
+		f.cg.ResumeAll()
+		f.cg.Pause(toPartitionsMap(f.paused))
+		f.pauseHeldLocked()
+	}
+}
+
+func (f *flowControl) watchHealth(ctx context.Context, fn func() error, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := fn()
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"component": "ConsumerGroupHandler",
+				}).Debug("health check returned an error")
+			}
+			f.setHealthy(err == nil)
+		}
+	}
+}
+
+func (f *flowControl) wait(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	if f.messageLimiter != nil {
+		if err := f.messageLimiter.Wait(ctx); err != nil {
+			return errors.Wrap(err, "error waiting for message rate limiter")
+		}
+	}
+
+	if f.byteLimiter != nil {
+		n := min(len(msg.Key)+len(msg.Value), f.byteLimiter.Burst())
+		if err := f.byteLimiter.WaitN(ctx, n); err != nil {
+			return errors.Wrap(err, "error waiting for byte rate limiter")
+		}
+	}
+
+	return nil
+}
+
+func addPartitions(dst map[string]map[int32]struct{}, partitions map[string][]int32) {
+	for topic, ps := range partitions {
+		if _, ok := dst[topic]; !ok {
+			dst[topic] = map[int32]struct{}{}
+		}
+		for _, p := range ps {
+			dst[topic][p] = struct{}{}
+		}
+	}
+}
+
+func removePartitions(dst map[string]map[int32]struct{}, partitions map[string][]int32) {
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			delete(dst[topic], p)
+		}
+		if len(dst[topic]) == 0 {
+			delete(dst, topic)
+		}
+	}
+}
+
+func toPartitionsMap(src map[string]map[int32]struct{}) map[string][]int32 {
+	out := make(map[string][]int32, len(src))
+	for topic, ps := range src {
+		for p := range ps {
+			out[topic] = append(out[topic], p)
+		}
+	}
+	return out
+}
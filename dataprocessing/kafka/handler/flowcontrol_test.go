@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestFlowControlPauseResume(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 2)
+
+	h := &recordingHandler{}
+	svc := New(cg, []string{"test-topic"}, h)
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	svc.Pause(map[string][]int32{"test-topic": {0}})
+	r.True(cg.Paused("test-topic", 0))
+	r.False(cg.Paused("test-topic", 1))
+
+	cg.ProduceValues("test-topic", 0, "a")
+	cg.ProduceValues("test-topic", 1, "b")
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 1, 1))
+	r.Equal([]string{"b"}, h.values())
+
+	svc.Resume(map[string][]int32{"test-topic": {0}})
+	r.False(cg.Paused("test-topic", 0))
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 1))
+	r.Equal([]string{"b", "a"}, h.values())
+
+	svc.PauseAll()
+	svc.Resume(map[string][]int32{"test-topic": {1}})
+	r.True(cg.Paused("test-topic", 0))
+	r.False(cg.Paused("test-topic", 1))
+
+	svc.ResumeAll()
+	r.False(cg.Paused("test-topic", 0))
+	r.False(cg.Paused("test-topic", 1))
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestFlowControlPauseBuffered(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	rh := &recordingHandler{}
+	h := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		started <- struct{}{}
+		<-release
+		return rh.Handle(ctx, msg)
+	})
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+
+	svc := New(cg, []string{"test-topic"}, h)
+	errCh := runService(ctx, svc)
+
+	<-started
+	// let the rest of messages to be fetched into the claim channel
+	time.Sleep(50 * time.Millisecond)
+	svc.Pause(map[string][]int32{"test-topic": {0}})
+	close(release)
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 1))
+	select {
+	case <-started:
+		r.FailNow("buffered message handled while partition is paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	svc.Resume(map[string][]int32{"test-topic": {0}})
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 3))
+	r.Equal([]string{"a", "b", "c"}, rh.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestFlowControlPauseSurvivesRebalance(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 1)
+
+	svc := New(cg, []string{"test-topic"}, &recordingHandler{})
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	svc.Pause(map[string][]int32{"test-topic": {0}})
+	r.True(cg.Paused("test-topic", 0))
+
+	cg.Rebalance()
+	r.NoError(cg.WaitGeneration(ctx, 2))
+	r.Eventually(func() bool {
+		return cg.Paused("test-topic", 0)
+	}, time.Second, 10*time.Millisecond)
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestFlowControlHealth(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 2)
+
+	svc := New(cg, []string{"test-topic"}, &recordingHandler{}).(*service)
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	svc.Pause(map[string][]int32{"test-topic": {0}})
+
+	svc.flow.setHealthy(false)
+	r.True(cg.Paused("test-topic", 0))
+	r.True(cg.Paused("test-topic", 1))
+
+	svc.Resume(map[string][]int32{"test-topic": {0}})
+	r.True(cg.Paused("test-topic", 0))
+
+	svc.Pause(map[string][]int32{"test-topic": {1}})
+	svc.flow.setHealthy(true)
+	r.False(cg.Paused("test-topic", 0))
+	r.True(cg.Paused("test-topic", 1))
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestFlowControlHoldSurvivesResume(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 2)
+
+	svc := New(cg, []string{"test-topic"}, &recordingHandler{}).(*service)
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	svc.flow.hold("test-topic", 0)
+	r.True(cg.Paused("test-topic", 0))
+
+	svc.flow.setHealthy(false)
+	svc.flow.setHealthy(true)
+	r.True(cg.Paused("test-topic", 0))
+	r.False(cg.Paused("test-topic", 1))
+
+	svc.PauseAll()
+	svc.flow.setHealthy(false)
+	svc.flow.setHealthy(true)
+	r.True(cg.Paused("test-topic", 0))
+
+	svc.Resume(map[string][]int32{"test-topic": {0, 1}})
+	r.True(cg.Paused("test-topic", 0))
+	r.False(cg.Paused("test-topic", 1))
+
+	svc.ResumeAll()
+	r.True(cg.Paused("test-topic", 0))
+
+	svc.flow.release("test-topic", 0)
+	r.False(cg.Paused("test-topic", 0))
+
+	// user pause is kept once the hold is released
+	svc.flow.hold("test-topic", 1)
+	svc.Pause(map[string][]int32{"test-topic": {1}})
+	svc.flow.release("test-topic", 1)
+	r.True(cg.Paused("test-topic", 1))
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestFlowControlWaitResumed(t *testing.T) {
+	r := require.New(t)
+
+	f := newFlowControl(fake.NewConsumerGroup())
+
+	resumed, err := f.waitResumed(context.TODO(), nil, "test-topic", 0)
+	r.NoError(err)
+	r.True(resumed)
+
+	f.Pause(map[string][]int32{"test-topic": {0}})
+
+	type result struct {
+		resumed bool
+		err     error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		resumed, err := f.waitResumed(context.TODO(), nil, "test-topic", 0)
+		resultCh <- result{resumed, err}
+	}()
+
+	select {
+	case <-resultCh:
+		r.FailNow("waitResumed is expected to block while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.setHealthy(false)
+	f.Resume(map[string][]int32{"test-topic": {0}})
+
+	select {
+	case <-resultCh:
+		r.FailNow("waitResumed is expected to block while health check is failing")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.setHealthy(true)
+	res := <-resultCh
+	r.NoError(res.err)
+	r.True(res.resumed)
+
+	f.PauseAll()
+	stopCh := make(chan struct{})
+	close(stopCh)
+
+	resumed, err = f.waitResumed(context.TODO(), stopCh, "test-topic", 0)
+	r.NoError(err)
+	r.False(resumed)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	cancelFn()
+
+	_, err = f.waitResumed(ctx, nil, "test-topic", 0)
+	r.ErrorIs(err, context.Canceled)
+}
+
+func TestFlowControlHealthDefaultInterval(t *testing.T) {
+	r := require.New(t)
+
+	s := New(fake.NewConsumerGroup(), nil, nil, WithHealthPause(func() error { return nil }, 0)).(*service)
+	r.Equal(defaultHealthInterval, s.healthInterval)
+}
+
+func TestFlowControlByteRateLimit(t *testing.T) {
+	r := require.New(t)
+
+	s := New(fake.NewConsumerGroup(), nil, nil, WithByteRateLimit(1024, 16)).(*service)
+
+	err := s.flow.wait(context.TODO(), &sarama.ConsumerMessage{Value: make([]byte, 64)})
+	r.NoError(err)
+}
+
+func TestFlowControlRateLimitValidation(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	msg := &sarama.ConsumerMessage{Value: make([]byte, 64)}
+
+	s := New(fake.NewConsumerGroup(), nil, nil, WithMessageRateLimit(1000, 0), WithByteRateLimit(1e6, -1)).(*service)
+	r.Equal(1, s.flow.messageLimiter.Burst())
+	r.Equal(1, s.flow.byteLimiter.Burst())
+	r.NoError(s.flow.wait(ctx, msg))
+	r.NoError(s.flow.wait(ctx, msg))
+
+	s = New(fake.NewConsumerGroup(), nil, nil, WithMessageRateLimit(0, 10), WithByteRateLimit(-1, 10)).(*service)
+	r.Nil(s.flow.messageLimiter)
+	r.Nil(s.flow.byteLimiter)
+	r.NoError(s.flow.wait(ctx, msg))
+}
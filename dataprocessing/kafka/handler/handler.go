@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -12,6 +13,8 @@ import (
 var (
 	_ sarama.ConsumerGroupHandler = (*consumerGroupHandler)(nil)
 
+	// ErrMarkAcked could be returned by the handler to mark the message as
+	// processed and proceed to the next one regardless of ErrorPolicy
 	ErrMarkAcked = errors.New("skip message")
 )
 
@@ -20,22 +23,153 @@ type Handler interface {
 }
 
 type consumerGroupHandler struct {
-	handler Handler
+	handler     Handler
+	flow        *flowControl
+	health      *health
+	replay      *replay
+	errorPolicy ErrorPolicy
+	notBefore   NotBeforeFunc
+
+	// stopCh is closed when claim loops must return once the current
+	// message is processed
+	stopCh <-chan struct{}
+
+	// inflight counts messages being processed across all the claims so
+	// idle claims don't end the session while others are draining
+	drainMutex sync.Mutex
+	inflight   int
+	drained    chan struct{}
+
+	fatalMutex sync.Mutex
+	fatalErr   error
+}
+
+func newConsumerGroupHandler(s *service, stopCh <-chan struct{}) *consumerGroupHandler {
+	return &consumerGroupHandler{
+		handler:     s.handler,
+		flow:        s.flow,
+		health:      s.health,
+		replay:      s.replay,
+		errorPolicy: s.errorPolicy,
+		notBefore:   s.notBefore,
+		stopCh:      stopCh,
+		drained:     make(chan struct{}),
+	}
+}
+
+// fatal returns the error the service must be stopped with if any
+func (h *consumerGroupHandler) fatal() error {
+	h.fatalMutex.Lock()
+	defer h.fatalMutex.Unlock()
+
+	return h.fatalErr
+}
+
+func (h *consumerGroupHandler) setFatal(err error) {
+	h.fatalMutex.Lock()
+	defer h.fatalMutex.Unlock()
+
+	if h.fatalErr == nil {
+		h.fatalErr = err
+	}
+}
+
+func (h *consumerGroupHandler) stopped() bool {
+	select {
+	case <-h.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// beginMessage registers the in-flight message. It returns false once
+// the stop is requested so the message must be left unprocessed.
+func (h *consumerGroupHandler) beginMessage() bool {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+
+	if h.stopped() {
+		return false
+	}
+
+	h.inflight++
+	return true
+}
+
+func (h *consumerGroupHandler) endMessage() {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+
+	h.inflight--
+	if h.inflight == 0 {
+		close(h.drained)
+		h.drained = make(chan struct{})
+	}
+}
+
+// idle reports whether there are no in-flight messages
+func (h *consumerGroupHandler) idle() bool {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+
+	return h.inflight == 0
+}
+
+// waitDrained blocks until all the in-flight messages of the session are
+// processed since the session ends once any claim returns
+func (h *consumerGroupHandler) waitDrained(ctx context.Context) {
+	for {
+		h.drainMutex.Lock()
+		n, drained := h.inflight, h.drained
+		h.drainMutex.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-drained:
+		}
+	}
 }
 
-func (h *consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
 	log.WithFields(log.Fields{
 		"component": "ConsumerGroupHandler",
 	}).Trace("Setup() called")
 
+	if err := h.replay.setup(session); err != nil {
+		return err
+	}
+
+	if rh, ok := h.handler.(RebalanceHandler); ok {
+		if err := rh.OnAssigned(session.Context(), session.Claims()); err != nil {
+			return errors.Wrap(err, "error running OnAssigned hook")
+		}
+	}
+
+	h.health.setAssigned(session.Claims())
+
 	return nil
 }
 
-func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
 	log.WithFields(log.Fields{
 		"component": "ConsumerGroupHandler",
 	}).Trace("Cleanup() called")
 
+	h.health.unassign()
+
+	if rh, ok := h.handler.(RebalanceHandler); ok {
+		// session context is already cancelled at the moment
+		if err := rh.OnRevoked(context.WithoutCancel(session.Context()), session.Claims()); err != nil {
+			return errors.Wrap(err, "error running OnRevoked hook")
+		}
+	}
+
 	return nil
 }
 
@@ -44,18 +178,81 @@ func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 		"component": "ConsumerGroupHandler",
 	}).Trace("ConsumeClaim() called")
 
+	h.health.claimStarted()
+	defer h.health.claimFinished()
+
+	ctx, cancelFn := newClaimContext(session.Context(), claim)
+	defer cancelFn()
+
+	handler, closeFn, err := h.claimHandler(ctx, claim)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := closeFn(); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+				"topic":     claim.Topic(),
+				"partition": claim.Partition(),
+			}).Warn("error closing partition handler")
+		}
+	}()
+
+	h.flow.apply(claim.Topic(), claim.Partition())
+
+	position := h.replay.resolve(claim.Topic(), claim.Partition(), claim.InitialOffset())
+	if h.replay.reached(claim.Topic(), claim.Partition(), position) ||
+		h.replay.caughtUp(claim.Topic(), claim.Partition(), position, claim.HighWaterMarkOffset(), false) {
+		return h.finishClaim(ctx, claim)
+	}
+
+	idleCh, stopIdle := h.replay.idleTicker()
+	defer stopIdle()
+	received := false
+
 	for {
+		if h.stopped() {
+			log.WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+				"topic":     claim.Topic(),
+				"partition": claim.Partition(),
+			}).Debug("stop requested. Leaving claim ...")
+
+			h.waitDrained(ctx)
+			return nil
+		}
+
+		closed, finished := false, false
 		if err := func() error {
 			defer session.Commit()
 
 			select {
-			case <-session.Context().Done():
-				return errors.Wrap(session.Context().Err(), "error received from context")
+			case <-h.stopCh:
+				return nil
+			case <-ctx.Done():
+				if h.stopped() {
+					return nil
+				}
+				return errors.Wrap(ctx.Err(), "error received from context")
+			case <-idleCh:
+				// offsets of transaction control records are never delivered
+				idle := !received && len(claim.Messages()) == 0 && !h.flow.isHeldOrPaused(claim.Topic(), claim.Partition())
+				received = false
+
+				finished = h.replay.caughtUp(claim.Topic(), claim.Partition(), position, claim.HighWaterMarkOffset(), idle)
+				return nil
 			case message, ok := <-claim.Messages():
 				if !ok {
-					log.Warn("message channel was closed")
+					log.WithFields(log.Fields{
+						"component": "ConsumerGroupHandler",
+						"topic":     claim.Topic(),
+						"partition": claim.Partition(),
+					}).Warn("message channel was closed")
+
+					closed = true
 					return nil
 				}
+				received = true
 
 				log.WithFields(log.Fields{
 					"topic":     message.Topic,
@@ -65,28 +262,113 @@ func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 					"length":    len(message.Value),
 				}).Debug("message consumed. Running handler ...")
 
-				if err := h.handler.Handle(session.Context(), message); err != nil {
-					if errors.Is(errors.Cause(err), ErrMarkAcked) {
-						log.WithError(err).WithFields(log.Fields{
-							"component": "ConsumerGroupHandler",
-						}).Debug("handler returned ErrMarkAcked. Marking message ...")
-						session.MarkMessage(message, "")
-					}
+				if h.replay.reached(message.Topic, message.Partition, message.Offset) {
+					finished = true
+					return nil
+				}
+
+				resumed, err := h.flow.waitResumed(ctx, h.stopCh, message.Topic, message.Partition)
+				if err != nil {
+					return errors.Wrap(err, "error waiting for partition to be resumed")
+				}
+				if !resumed {
+					return nil
+				}
 
-					log.WithError(err).Error("error running handler. Not marking message")
-					return errors.Wrap(err, "error running handler")
+				due, err := h.waitDue(ctx, message)
+				if err != nil || !due {
+					return err
+				}
+
+				if err := h.flow.wait(ctx, message); err != nil {
+					return err
+				}
+
+				if !h.beginMessage() {
+					return nil
+				}
+				defer h.endMessage()
+
+				if err := h.process(ctx, session, handler, message); err != nil {
+					return err
 				}
-				log.WithFields(log.Fields{
-					"component": "ConsumerGroupHandler",
-				}).Debug("handler completed without an error. Marking message ...")
 
-				session.MarkMessage(message, "")
+				position = message.Offset + 1
+				finished = h.replay.reached(message.Topic, message.Partition, position) ||
+					h.replay.caughtUp(message.Topic, message.Partition, position, claim.HighWaterMarkOffset(), false)
 				return nil
 			}
 		}(); err != nil {
 			log.WithError(err).Error("error running consumer group handler")
 			return errors.Wrap(err, "error running consumer group handler")
 		}
+
+		if closed {
+			return nil
+		}
+
+		if finished {
+			return h.finishClaim(ctx, claim)
+		}
+	}
+}
+
+func (h *consumerGroupHandler) process(ctx context.Context, session sarama.ConsumerGroupSession, handler Handler, message *sarama.ConsumerMessage) error {
+	h.health.begin(message.Topic, message.Partition)
+	defer h.health.end(message.Topic, message.Partition)
+
+	for attempt := 1; ; attempt++ {
+		err := handler.Handle(ctx, message)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+			}).Debug("handler completed without an error. Marking message ...")
+
+			session.MarkMessage(message, "")
+			return nil
+		}
+
+		decision := DecisionSkip
+		if !errors.Is(err, ErrMarkAcked) {
+			decision = h.errorPolicy.OnError(ctx, message, attempt, err)
+		}
+
+		logger := log.WithError(err).WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+			"topic":     message.Topic,
+			"partition": message.Partition,
+			"offset":    message.Offset,
+			"attempt":   attempt,
+			"decision":  decision.String(),
+		})
+
+		switch decision {
+		case DecisionSkip:
+			logger.Warn("error running handler. Marking message ...")
+			session.MarkMessage(message, "")
+			return nil
+		case DecisionRetry:
+			logger.Warn("error running handler. Retrying ...")
+		case DecisionPause:
+			logger.Warn("error running handler. Pausing partition ...")
+			// waiting for the resume is not the processing to be considered as stuck
+			h.health.end(message.Topic, message.Partition)
+			if err := h.flow.pauseAndWait(ctx, message.Topic, message.Partition); err != nil {
+				return errors.Wrap(err, "error waiting for partition to be resumed")
+			}
+			h.health.begin(message.Topic, message.Partition)
+		case DecisionStop:
+			logger.Error("error running handler. Stopping ...")
+			h.setFatal(err)
+			return errors.Wrap(err, "error running handler")
+		default:
+			logger.Error("error running handler. Not marking message")
+			return errors.Wrap(err, "error running handler")
+		}
+
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "error received from context")
+		}
 	}
 }
 
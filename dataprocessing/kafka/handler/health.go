@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxPollInterval   = time.Minute
+	defaultMaxProcessingTime = 5 * time.Minute
+)
+
+var (
+	ErrNotStarted  = errors.New("consumer group session was never started")
+	ErrNotAssigned = errors.New("no partitions assigned")
+)
+
+// WithHealthThresholds sets the thresholds used by the health functions:
+// Readiness fails when all the claims are busy and no message was received
+// for maxPollInterval, Liveness fails when the message is processed longer
+// than maxProcessingTime. Defaults are 1m and 5m.
+func WithHealthThresholds(maxPollInterval, maxProcessingTime time.Duration) Option {
+	return func(s *service) {
+		s.health.maxPollInterval = maxPollInterval
+		s.health.maxProcessingTime = maxProcessingTime
+	}
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// health tracks the state of the consumer group sessions and claim loops
+type health struct {
+	maxPollInterval   time.Duration
+	maxProcessingTime time.Duration
+
+	mutex    sync.Mutex
+	started  bool
+	assigned int
+	claims   int
+	inflight map[partitionKey]time.Time
+	lastPoll time.Time
+}
+
+func newHealth() *health {
+	return &health{
+		maxPollInterval:   defaultMaxPollInterval,
+		maxProcessingTime: defaultMaxProcessingTime,
+		inflight:          map[partitionKey]time.Time{},
+	}
+}
+
+func (h *health) setAssigned(claims map[string][]int32) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.started = true
+	h.assigned = 0
+	for _, ps := range claims {
+		h.assigned += len(ps)
+	}
+	h.lastPoll = time.Now()
+}
+
+func (h *health) unassign() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.assigned = 0
+}
+
+func (h *health) claimStarted() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.claims++
+}
+
+func (h *health) claimFinished() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.claims--
+}
+
+// begin marks the message of the partition is being processed
+func (h *health) begin(topic string, partition int32) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	h.inflight[partitionKey{topic, partition}] = now
+	h.lastPoll = now
+}
+
+func (h *health) end(topic string, partition int32) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.inflight, partitionKey{topic, partition})
+}
+
+// Startup succeeds once the first consumer group session was set up
+func (h *health) Startup() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.started {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+// Readiness succeeds while partitions are assigned and at least one claim
+// loop is waiting for messages or the last message was received recently
+func (h *health) Readiness() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.assigned == 0 {
+		return ErrNotAssigned
+	}
+
+	if h.claims > len(h.inflight) {
+		return nil
+	}
+
+	if since := time.Since(h.lastPoll); since > h.maxPollInterval {
+		return errors.Errorf("last poll was %s ago", since.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Liveness fails if any claim loop is stuck on the single message
+func (h *health) Liveness() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for k, started := range h.inflight {
+		if since := time.Since(started); since > h.maxProcessingTime {
+			return errors.Errorf(
+				"message processing in %s/%d is stuck for %s",
+				k.topic, k.partition, since.Round(time.Millisecond),
+			)
+		}
+	}
+	return nil
+}
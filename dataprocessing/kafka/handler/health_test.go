@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/applications/metrics"
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestHealth(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 1)
+
+	releaseCh := make(chan struct{})
+	h := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		<-releaseCh
+		return nil
+	})
+
+	svc := New(cg, []string{"test-topic"}, h, WithHealthThresholds(100*time.Millisecond, 200*time.Millisecond))
+
+	// the shape is pluggable to applications/metrics
+	_ = metrics.New(svc.Liveness, svc.Readiness, svc.Startup)
+
+	r.ErrorIs(svc.Startup(), ErrNotStarted)
+	r.ErrorIs(svc.Readiness(), ErrNotAssigned)
+	r.NoError(svc.Liveness())
+
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	r.NoError(svc.Startup())
+	r.NoError(svc.Readiness())
+	r.NoError(svc.Liveness())
+
+	cg.ProduceValues("test-topic", 0, "stuck")
+
+	r.Eventually(func() bool {
+		return svc.Readiness() != nil
+	}, time.Second, 10*time.Millisecond)
+	r.ErrorContains(svc.Readiness(), "last poll was")
+
+	r.Eventually(func() bool {
+		return svc.Liveness() != nil
+	}, time.Second, 10*time.Millisecond)
+	r.ErrorContains(svc.Liveness(), "message processing in test-topic/0 is stuck")
+
+	close(releaseCh)
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 1))
+	r.Eventually(func() bool {
+		return svc.Readiness() == nil && svc.Liveness() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancelFn()
+	r.NoError(<-errCh)
+
+	r.NoError(svc.Startup())
+	r.ErrorIs(svc.Readiness(), ErrNotAssigned)
+}
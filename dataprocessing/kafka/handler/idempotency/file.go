@@ -0,0 +1,291 @@
+package idempotency
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ ClosableStore = (*fileStore)(nil)
+
+type ClosableStore interface {
+	Store
+
+	Close() error
+}
+
+// minCompactLines is the amount of lines in the file below which it's
+// never compacted while the store is open
+const minCompactLines = 1024
+
+type FileStoreOption func(s *fileStore)
+
+// WithMaxKeys limits the amount of keys kept by the store, the keys recorded
+// earlier are evicted first. Zero means no limit.
+func WithMaxKeys(n int) FileStoreOption {
+	return func(s *fileStore) {
+		s.maxKeys = n
+	}
+}
+
+type fileEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+type fileStore struct {
+	mutex        sync.Mutex
+	path         string
+	ttl          time.Duration
+	maxKeys      int
+	entries      *list.List
+	keys         map[string]*list.Element
+	lines        int
+	compactLines int
+	fp           *os.File
+	nowFn        func() time.Time
+}
+
+// NewFileStore creates the store persisting keys to the append-only file
+// at path so they survive service restarts. Zero ttl means keys never
+// expire. The file is compacted on open and once it has twice as many lines
+// as the keys kept: expired, evicted and duplicate keys are dropped.
+func NewFileStore(path string, ttl time.Duration, opts ...FileStoreOption) (ClosableStore, error) {
+	s := &fileStore{
+		path:         path,
+		ttl:          ttl,
+		entries:      list.New(),
+		keys:         map[string]*list.Element{},
+		compactLines: minCompactLines,
+		nowFn:        time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.maxKeys < 0 {
+		return nil, errors.New("max keys must not be negative")
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileStore) Seen(_ context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	el, ok := s.keys[key]
+	if !ok {
+		return false, nil
+	}
+
+	if s.expired(el.Value.(*fileEntry).expiresAt) {
+		s.remove(el)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *fileStore) Record(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var expiresAt time.Time
+	if s.ttl > 0 {
+		expiresAt = s.nowFn().Add(s.ttl)
+	}
+
+	if _, err := s.fp.WriteString(formatLine(key, expiresAt)); err != nil {
+		return errors.Wrap(err, "error writing key to store file")
+	}
+	s.lines++
+
+	s.set(key, expiresAt)
+	s.evictExpired()
+
+	if s.lines < s.compactLines || s.lines < 2*s.entries.Len() {
+		return nil
+	}
+
+	if err := s.fp.Close(); err != nil {
+		return errors.Wrap(err, "error closing store file")
+	}
+
+	// the file must be reopened anyway to keep recording keys
+	compactErr := s.compact()
+	if err := s.open(); err != nil {
+		return err
+	}
+	return compactErr
+}
+
+func (s *fileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.fp.Close()
+}
+
+func (s *fileStore) load() error {
+	fp, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "error opening store file")
+	}
+	defer func() { _ = fp.Close() }()
+
+	scanner := bufio.NewScanner(fp)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		key, expiresAt, err := parseLine(scanner.Text())
+		if err != nil {
+			return errors.Wrapf(err, "error parsing store file line %d", lineNo)
+		}
+
+		// the latest line of the key is the actual one
+		s.set(key, expiresAt)
+	}
+
+	return errors.Wrap(scanner.Err(), "error reading store file")
+}
+
+func (s *fileStore) open() error {
+	fp, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "error opening store file")
+	}
+
+	s.fp = fp
+	return nil
+}
+
+// set adds the key or refreshes the existing one evicting the keys recorded
+// earliest if the limit is exceeded
+func (s *fileStore) set(key string, expiresAt time.Time) {
+	if el, ok := s.keys[key]; ok {
+		s.remove(el)
+	}
+
+	s.keys[key] = s.entries.PushBack(&fileEntry{
+		key:       key,
+		expiresAt: expiresAt,
+	})
+
+	for s.maxKeys > 0 && s.entries.Len() > s.maxKeys {
+		s.remove(s.entries.Front())
+	}
+}
+
+// evictExpired drops expired keys from the front of the list: since the keys
+// are recorded with the same ttl they're expiring in the order of the list
+func (s *fileStore) evictExpired() {
+	for el := s.entries.Front(); el != nil && s.expired(el.Value.(*fileEntry).expiresAt); el = s.entries.Front() {
+		s.remove(el)
+	}
+}
+
+func (s *fileStore) remove(el *list.Element) {
+	s.entries.Remove(el)
+	delete(s.keys, el.Value.(*fileEntry).key)
+}
+
+// compact rewrites the file with non-expired keys only in the order they
+// were recorded
+func (s *fileStore) compact() error {
+	for el := s.entries.Front(); el != nil; {
+		next := el.Next()
+		if s.expired(el.Value.(*fileEntry).expiresAt) {
+			s.remove(el)
+		}
+		el = next
+	}
+
+	tmp := s.path + ".tmp"
+
+	fp, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary store file")
+	}
+
+	w := bufio.NewWriter(fp)
+	for el := s.entries.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*fileEntry)
+		if _, err := w.WriteString(formatLine(e.key, e.expiresAt)); err != nil {
+			_ = fp.Close()
+			return errors.Wrap(err, "error writing temporary store file")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = fp.Close()
+		return errors.Wrap(err, "error writing temporary store file")
+	}
+
+	if err := fp.Close(); err != nil {
+		return errors.Wrap(err, "error closing temporary store file")
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return errors.Wrap(err, "error replacing store file")
+	}
+
+	s.lines = s.entries.Len()
+	return nil
+}
+
+func (s *fileStore) expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !s.nowFn().Before(expiresAt)
+}
+
+func formatLine(key string, expiresAt time.Time) string {
+	var ts int64
+	if !expiresAt.IsZero() {
+		ts = expiresAt.UnixNano()
+	}
+	return fmt.Sprintf("%d\t%s\n", ts, strconv.Quote(key))
+}
+
+func parseLine(line string) (string, time.Time, error) {
+	tsStr, quoted, ok := strings.Cut(line, "\t")
+	if !ok {
+		return "", time.Time{}, errors.New("unexpected line format")
+	}
+
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "error parsing expiration timestamp")
+	}
+
+	key, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "error parsing key")
+	}
+
+	var expiresAt time.Time
+	if ts > 0 {
+		expiresAt = time.Unix(0, ts)
+	}
+	return key, expiresAt, nil
+}
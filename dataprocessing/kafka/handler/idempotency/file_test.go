@@ -0,0 +1,141 @@
+package idempotency
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+
+	s, err := NewFileStore(path, time.Hour)
+	r.NoError(err)
+
+	r.NoError(s.Record(ctx, "a"))
+	r.NoError(s.Record(ctx, "key with\ttab and\nnewline"))
+	r.NoError(s.Close())
+
+	s, err = NewFileStore(path, time.Hour)
+	r.NoError(err)
+	defer func() { r.NoError(s.Close()) }()
+
+	for key, exp := range map[string]bool{"a": true, "key with\ttab and\nnewline": true, "b": false} {
+		seen, err := s.Seen(ctx, key)
+		r.NoError(err)
+		r.Equal(exp, seen, key)
+	}
+}
+
+func TestFileStoreExpiredKeysCompaction(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+
+	expired := formatLine("expired", time.Now().Add(-time.Minute))
+	permanent := formatLine("permanent", time.Time{})
+	r.NoError(os.WriteFile(path, []byte(expired+permanent), 0o600))
+
+	s, err := NewFileStore(path, time.Hour)
+	r.NoError(err)
+	defer func() { r.NoError(s.Close()) }()
+
+	seen, err := s.Seen(ctx, "expired")
+	r.NoError(err)
+	r.False(seen)
+
+	seen, err = s.Seen(ctx, "permanent")
+	r.NoError(err)
+	r.True(seen)
+
+	data, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Equal(permanent, string(data))
+}
+
+func TestFileStoreMaxKeys(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+
+	s, err := NewFileStore(path, 0, WithMaxKeys(2))
+	r.NoError(err)
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		r.NoError(s.Record(ctx, key))
+	}
+	r.NoError(s.Close())
+
+	s, err = NewFileStore(path, 0, WithMaxKeys(2))
+	r.NoError(err)
+	defer func() { r.NoError(s.Close()) }()
+
+	for key, exp := range map[string]bool{"a": true, "b": false, "c": true} {
+		seen, err := s.Seen(ctx, key)
+		r.NoError(err)
+		r.Equal(exp, seen, key)
+	}
+
+	data, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Equal(formatLine("a", time.Time{})+formatLine("c", time.Time{}), string(data))
+
+	_, err = NewFileStore(path, 0, WithMaxKeys(-1))
+	r.Error(err)
+}
+
+func TestFileStoreCompactionOnRecord(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+
+	cs, err := NewFileStore(path, time.Minute)
+	r.NoError(err)
+	defer func() { r.NoError(cs.Close()) }()
+
+	now := time.Now()
+	s := cs.(*fileStore)
+	s.compactLines = 4
+	s.nowFn = func() time.Time { return now }
+
+	r.NoError(s.Record(ctx, "a"))
+	r.NoError(s.Record(ctx, "b"))
+	r.NoError(s.Record(ctx, "b"))
+
+	now = now.Add(time.Hour)
+
+	// the 4th line triggers compaction dropping expired keys
+	r.NoError(s.Record(ctx, "c"))
+
+	data, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Equal(formatLine("c", now.Add(time.Minute)), string(data))
+	r.Len(s.keys, 1)
+
+	// the file is still written after compaction
+	r.NoError(s.Record(ctx, "d"))
+
+	data, err = os.ReadFile(path)
+	r.NoError(err)
+	r.Equal(formatLine("c", now.Add(time.Minute))+formatLine("d", now.Add(time.Minute)), string(data))
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+	r.NoError(os.WriteFile(path, []byte("garbage\n"), 0o600))
+
+	_, err := NewFileStore(path, time.Hour)
+	r.Error(err)
+}
@@ -0,0 +1,166 @@
+// Package idempotency implements handler middleware to skip messages which
+// were already processed successfully
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler"
+)
+
+var (
+	_ handler.Handler                 = (*idempotentHandler)(nil)
+	_ handler.RebalanceHandler        = (*idempotentHandler)(nil)
+	_ handler.PartitionHandlerFactory = (*idempotentHandler)(nil)
+
+	ErrNoKey = errors.New("no deduplication key in message")
+)
+
+// KeyFunc derives deduplication key from the message
+type KeyFunc func(msg *sarama.ConsumerMessage) (string, error)
+
+// MessageKey uses message key as deduplication key
+func MessageKey() KeyFunc {
+	return func(msg *sarama.ConsumerMessage) (string, error) {
+		if len(msg.Key) == 0 {
+			return "", errors.Wrap(ErrNoKey, "message key is empty")
+		}
+		return string(msg.Key), nil
+	}
+}
+
+// Header uses value of the header as deduplication key
+func Header(name string) KeyFunc {
+	return func(msg *sarama.ConsumerMessage) (string, error) {
+		for _, h := range msg.Headers {
+			if h != nil && string(h.Key) == name && len(h.Value) > 0 {
+				return string(h.Value), nil
+			}
+		}
+		return "", errors.Wrapf(ErrNoKey, "header `%s` is not set", name)
+	}
+}
+
+// ValueHash uses SHA256 hash of the message value as deduplication key
+func ValueHash() KeyFunc {
+	return func(msg *sarama.ConsumerMessage) (string, error) {
+		sum := sha256.Sum256(msg.Value)
+		return hex.EncodeToString(sum[:]), nil
+	}
+}
+
+// Store keeps the keys of successfully processed messages
+type Store interface {
+	Seen(ctx context.Context, key string) (bool, error)
+	Record(ctx context.Context, key string) error
+}
+
+type idempotentHandler struct {
+	next  handler.Handler
+	keyFn KeyFunc
+	store Store
+}
+
+// New wraps the handler to skip messages with the keys already recorded in
+// the store. Keys are recorded once the handler completed without an error.
+// RebalanceHandler and PartitionHandlerFactory of the handler are preserved.
+func New(next handler.Handler, keyFn KeyFunc, store Store) handler.Handler {
+	return &idempotentHandler{
+		next:  next,
+		keyFn: keyFn,
+		store: store,
+	}
+}
+
+func (h *idempotentHandler) OnAssigned(ctx context.Context, claims map[string][]int32) error {
+	if rh, ok := h.next.(handler.RebalanceHandler); ok {
+		return rh.OnAssigned(ctx, claims)
+	}
+	return nil
+}
+
+func (h *idempotentHandler) OnRevoked(ctx context.Context, claims map[string][]int32) error {
+	if rh, ok := h.next.(handler.RebalanceHandler); ok {
+		return rh.OnRevoked(ctx, claims)
+	}
+	return nil
+}
+
+// NewPartitionHandler wraps the partition handler created by the handler if
+// it implements PartitionHandlerFactory
+func (h *idempotentHandler) NewPartitionHandler(ctx context.Context, topic string, partition int32) (handler.PartitionHandler, error) {
+	f, ok := h.next.(handler.PartitionHandlerFactory)
+	if !ok {
+		return &partitionHandler{
+			idempotentHandler: h,
+			closeFn:           func() error { return nil },
+		}, nil
+	}
+
+	ph, err := f.NewPartitionHandler(ctx, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partitionHandler{
+		idempotentHandler: &idempotentHandler{
+			next:  ph,
+			keyFn: h.keyFn,
+			store: h.store,
+		},
+		closeFn: ph.Close,
+	}, nil
+}
+
+func (h *idempotentHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	key, err := h.keyFn(msg)
+	if err != nil {
+		return errors.Wrap(err, "error deriving deduplication key")
+	}
+
+	seen, err := h.store.Seen(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "error checking deduplication store")
+	}
+
+	if seen {
+		log.WithFields(log.Fields{
+			"component": "IdempotentHandler",
+			"topic":     msg.Topic,
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"key":       key,
+		}).Debug("message was already processed. Skipping ...")
+		return nil
+	}
+
+	if err := h.next.Handle(ctx, msg); err != nil {
+		return err
+	}
+
+	// the message is already processed so it must be marked anyway
+	if err := h.store.Record(ctx, key); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"component": "IdempotentHandler",
+			"key":       key,
+		}).Warn("error recording processed message key")
+	}
+
+	return nil
+}
+
+type partitionHandler struct {
+	*idempotentHandler
+
+	closeFn func() error
+}
+
+func (h *partitionHandler) Close() error {
+	return h.closeFn()
+}
@@ -0,0 +1,180 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler"
+)
+
+func TestIdempotentHandler(t *testing.T) {
+	r := require.New(t)
+
+	calls := 0
+	fail := true
+	h := New(handler.HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+		calls++
+		if fail {
+			fail = false
+			return errors.New("blah")
+		}
+		return nil
+	}), MessageKey(), NewMemoryStore(10, 0))
+
+	msg := &sarama.ConsumerMessage{Key: []byte("key"), Value: []byte("value")}
+
+	r.Error(h.Handle(context.TODO(), msg))
+	r.NoError(h.Handle(context.TODO(), msg))
+	r.NoError(h.Handle(context.TODO(), msg))
+	r.Equal(2, calls)
+
+	err := h.Handle(context.TODO(), &sarama.ConsumerMessage{Value: []byte("value")})
+	r.ErrorIs(err, ErrNoKey)
+	r.Equal(2, calls)
+}
+
+func TestIdempotentHandlerForwarding(t *testing.T) {
+	r := require.New(t)
+
+	next := &statefulHandler{}
+	h := New(next, MessageKey(), NewMemoryStore(10, 0))
+
+	claims := map[string][]int32{"test-topic": {0}}
+
+	rh, ok := h.(handler.RebalanceHandler)
+	r.True(ok)
+	r.NoError(rh.OnAssigned(context.TODO(), claims))
+	r.NoError(rh.OnRevoked(context.TODO(), claims))
+	r.Equal(claims, next.assigned)
+	r.Equal(claims, next.revoked)
+
+	f, ok := h.(handler.PartitionHandlerFactory)
+	r.True(ok)
+
+	ph, err := f.NewPartitionHandler(context.TODO(), "test-topic", 0)
+	r.NoError(err)
+
+	msg := &sarama.ConsumerMessage{Key: []byte("key")}
+	r.NoError(ph.Handle(context.TODO(), msg))
+	r.NoError(ph.Handle(context.TODO(), msg))
+	r.NoError(ph.Close())
+
+	r.Equal(1, next.partition.calls)
+	r.True(next.partition.closed)
+
+	// handlers without optional interfaces are still supported
+	h = New(handler.HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	}), MessageKey(), NewMemoryStore(10, 0))
+
+	r.NoError(h.(handler.RebalanceHandler).OnAssigned(context.TODO(), claims))
+
+	ph, err = h.(handler.PartitionHandlerFactory).NewPartitionHandler(context.TODO(), "test-topic", 0)
+	r.NoError(err)
+	r.NoError(ph.Handle(context.TODO(), msg))
+	r.NoError(ph.Close())
+}
+
+func TestKeyFuncs(t *testing.T) {
+	type testCase struct {
+		name   string
+		keyFn  KeyFunc
+		msg    *sarama.ConsumerMessage
+		expKey string
+		expErr error
+	}
+
+	tcs := []testCase{
+		{
+			name:   "message key",
+			keyFn:  MessageKey(),
+			msg:    &sarama.ConsumerMessage{Key: []byte("key")},
+			expKey: "key",
+		},
+		{
+			name:   "empty message key",
+			keyFn:  MessageKey(),
+			msg:    &sarama.ConsumerMessage{},
+			expErr: ErrNoKey,
+		},
+		{
+			name:  "header",
+			keyFn: Header("x-request-id"),
+			msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				{Key: []byte("x-other"), Value: []byte("other")},
+				{Key: []byte("x-request-id"), Value: []byte("req-1")},
+			}},
+			expKey: "req-1",
+		},
+		{
+			name:   "missing header",
+			keyFn:  Header("x-request-id"),
+			msg:    &sarama.ConsumerMessage{},
+			expErr: ErrNoKey,
+		},
+		{
+			name:   "value hash",
+			keyFn:  ValueHash(),
+			msg:    &sarama.ConsumerMessage{Value: []byte("test")},
+			expKey: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			key, err := tc.keyFn(tc.msg)
+			if tc.expErr != nil {
+				r.ErrorIs(err, tc.expErr)
+				return
+			}
+			r.NoError(err)
+			r.Equal(tc.expKey, key)
+		})
+	}
+}
+
+type statefulHandler struct {
+	assigned  map[string][]int32
+	revoked   map[string][]int32
+	partition *testPartitionHandler
+}
+
+func (h *statefulHandler) Handle(context.Context, *sarama.ConsumerMessage) error {
+	panic("must not be called for partition handlers")
+}
+
+func (h *statefulHandler) OnAssigned(_ context.Context, claims map[string][]int32) error {
+	h.assigned = claims
+	return nil
+}
+
+func (h *statefulHandler) OnRevoked(_ context.Context, claims map[string][]int32) error {
+	h.revoked = claims
+	return nil
+}
+
+func (h *statefulHandler) NewPartitionHandler(context.Context, string, int32) (handler.PartitionHandler, error) {
+	h.partition = &testPartitionHandler{}
+	return h.partition, nil
+}
+
+type testPartitionHandler struct {
+	calls  int
+	closed bool
+}
+
+func (h *testPartitionHandler) Handle(context.Context, *sarama.ConsumerMessage) error {
+	h.calls++
+	return nil
+}
+
+func (h *testPartitionHandler) Close() error {
+	h.closed = true
+	return nil
+}
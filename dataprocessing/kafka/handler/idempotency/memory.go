@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = (*memoryStore)(nil)
+
+type memoryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	index   map[string]*list.Element
+	nowFn   func() time.Time
+}
+
+// NewMemoryStore creates in-memory LRU store keeping up to size keys for
+// ttl each. Zero ttl means keys never expire.
+func NewMemoryStore(size int, ttl time.Duration) Store {
+	return &memoryStore{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element, size),
+		nowFn:   time.Now,
+	}
+}
+
+func (s *memoryStore) Seen(_ context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	el, ok := s.index[key]
+	if !ok {
+		return false, nil
+	}
+
+	if s.expired(el.Value.(*memoryEntry)) {
+		s.entries.Remove(el)
+		delete(s.index, key)
+		return false, nil
+	}
+
+	s.entries.MoveToFront(el)
+	return true, nil
+}
+
+func (s *memoryStore) Record(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var expiresAt time.Time
+	if s.ttl > 0 {
+		expiresAt = s.nowFn().Add(s.ttl)
+	}
+
+	if el, ok := s.index[key]; ok {
+		el.Value.(*memoryEntry).expiresAt = expiresAt
+		s.entries.MoveToFront(el)
+		return nil
+	}
+
+	s.index[key] = s.entries.PushFront(&memoryEntry{
+		key:       key,
+		expiresAt: expiresAt,
+	})
+
+	for s.size > 0 && s.entries.Len() > s.size {
+		el := s.entries.Back()
+		s.entries.Remove(el)
+		delete(s.index, el.Value.(*memoryEntry).key)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) expired(e *memoryEntry) bool {
+	return !e.expiresAt.IsZero() && !s.nowFn().Before(e.expiresAt)
+}
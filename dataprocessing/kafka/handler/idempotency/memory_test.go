@@ -0,0 +1,59 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/time/mock"
+)
+
+func TestMemoryStoreEviction(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	s := NewMemoryStore(2, 0)
+
+	r.NoError(s.Record(ctx, "a"))
+	r.NoError(s.Record(ctx, "b"))
+
+	// touch `a` so `b` becomes the least recently used
+	seen, err := s.Seen(ctx, "a")
+	r.NoError(err)
+	r.True(seen)
+
+	r.NoError(s.Record(ctx, "c"))
+
+	for key, exp := range map[string]bool{"a": true, "b": false, "c": true} {
+		seen, err := s.Seen(ctx, key)
+		r.NoError(err)
+		r.Equal(exp, seen, key)
+	}
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	tm := mock.NewTimeNowMock()
+	defer tm.AssertExpectations(t)
+
+	tm.On("Now").Return("2024-07-06T10:00:00Z").Once()
+	tm.On("Now").Return("2024-07-06T10:00:59Z").Once()
+	tm.On("Now").Return("2024-07-06T10:01:00Z").Once()
+
+	s := NewMemoryStore(10, time.Minute)
+	s.(*memoryStore).nowFn = tm.Now
+
+	r.NoError(s.Record(ctx, "a"))
+
+	seen, err := s.Seen(ctx, "a")
+	r.NoError(err)
+	r.True(seen)
+
+	seen, err = s.Seen(ctx, "a")
+	r.NoError(err)
+	r.False(seen)
+}
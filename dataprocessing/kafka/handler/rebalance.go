@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+)
+
+// RebalanceHandler could be optionally implemented by Handler to get
+// notified about partition assignment changes. Returning an error from
+// OnAssigned aborts the session.
+type RebalanceHandler interface {
+	OnAssigned(ctx context.Context, claims map[string][]int32) error
+	OnRevoked(ctx context.Context, claims map[string][]int32) error
+}
+
+// PartitionHandler is the handler bound to the single claimed partition.
+// Close is called once the claim is over: on rebalance or shutdown.
+type PartitionHandler interface {
+	Handler
+
+	Close() error
+}
+
+// PartitionHandlerFactory could be optionally implemented by Handler to
+// create dedicated stateful handler per claimed partition. The context is
+// scoped to the claim and cancelled once the claim is over.
+type PartitionHandlerFactory interface {
+	NewPartitionHandler(ctx context.Context, topic string, partition int32) (PartitionHandler, error)
+}
+
+// Claim describes partition claim the message is consumed from
+type Claim struct {
+	Topic         string
+	Partition     int32
+	InitialOffset int64
+}
+
+type claimCtxKey struct{}
+
+// ClaimFromContext returns the claim from context passed to the handlers
+func ClaimFromContext(ctx context.Context) (Claim, bool) {
+	c, ok := ctx.Value(claimCtxKey{}).(Claim)
+	return c, ok
+}
+
+func newClaimContext(ctx context.Context, claim sarama.ConsumerGroupClaim) (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.WithValue(ctx, claimCtxKey{}, Claim{
+		Topic:         claim.Topic(),
+		Partition:     claim.Partition(),
+		InitialOffset: claim.InitialOffset(),
+	}))
+}
+
+func (h *consumerGroupHandler) claimHandler(ctx context.Context, claim sarama.ConsumerGroupClaim) (Handler, func() error, error) {
+	f, ok := h.handler.(PartitionHandlerFactory)
+	if !ok {
+		return h.handler, func() error { return nil }, nil
+	}
+
+	ph, err := f.NewPartitionHandler(ctx, claim.Topic(), claim.Partition())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error creating handler for partition %d of topic `%s`", claim.Partition(), claim.Topic())
+	}
+	return ph, ph.Close, nil
+}
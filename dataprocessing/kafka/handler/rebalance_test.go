@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestRebalanceHooks(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	h := &statefulHandler{
+		handled:    make(chan Claim, 2),
+		partitions: map[int32]*partitionHandler{},
+	}
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a")
+	cg.ProduceValues("test-topic", 1, "b")
+
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h))
+
+	handled := []Claim{<-h.handled, <-h.handled}
+	cancelFn()
+	r.NoError(<-errCh)
+
+	r.ElementsMatch([]Claim{
+		{Topic: "test-topic", Partition: 0},
+		{Topic: "test-topic", Partition: 1},
+	}, handled)
+
+	r.Equal(map[string][]int32{"test-topic": {0, 1}}, h.assigned)
+	r.Equal(map[string][]int32{"test-topic": {0, 1}}, h.revoked)
+
+	r.Len(h.partitions, 2)
+	for p, ph := range h.partitions {
+		r.Equal(1, ph.calls, "partition %d", p)
+		r.True(ph.closed, "partition %d", p)
+	}
+}
+
+type statefulHandler struct {
+	assigned map[string][]int32
+	revoked  map[string][]int32
+	handled  chan Claim
+
+	mutex      sync.Mutex
+	partitions map[int32]*partitionHandler
+	created    int
+}
+
+func (h *statefulHandler) Handle(context.Context, *sarama.ConsumerMessage) error {
+	panic("must not be called for partition handlers")
+}
+
+func (h *statefulHandler) OnAssigned(_ context.Context, claims map[string][]int32) error {
+	h.assigned = claims
+	return nil
+}
+
+func (h *statefulHandler) OnRevoked(ctx context.Context, claims map[string][]int32) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	h.revoked = claims
+	return nil
+}
+
+func (h *statefulHandler) NewPartitionHandler(_ context.Context, _ string, partition int32) (PartitionHandler, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ph := &partitionHandler{handled: h.handled}
+	h.partitions[partition] = ph
+	h.created++
+	return ph, nil
+}
+
+type partitionHandler struct {
+	calls   int
+	closed  bool
+	handled chan Claim
+}
+
+func (h *partitionHandler) Handle(ctx context.Context, _ *sarama.ConsumerMessage) error {
+	h.calls++
+
+	claim, ok := ClaimFromContext(ctx)
+	if !ok {
+		return errors.New("no claim in context")
+	}
+	h.handled <- claim
+	return nil
+}
+
+func (h *partitionHandler) Close() error {
+	h.closed = true
+	return nil
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// replayIdleInterval is the interval bounded replay checks whether the
+// partitions without messages delivered are caught up
+const replayIdleInterval = time.Second
+
+// OffsetResolver looks up partition offsets, implemented by sarama.Client
+type OffsetResolver interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// Position returns the offset of the partition. ok is false when the
+// position is not defined for the partition.
+type Position func(topic string, partition int32) (offset int64, ok bool, err error)
+
+// Earliest is the oldest available offset of each partition
+func Earliest(client OffsetResolver) Position {
+	return func(topic string, partition int32) (int64, bool, error) {
+		offset, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "error getting earliest offset of %s/%d", topic, partition)
+		}
+		return offset, true, nil
+	}
+}
+
+// Latest is the offset of the next message produced to each partition
+func Latest(client OffsetResolver) Position {
+	return func(topic string, partition int32) (int64, bool, error) {
+		offset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "error getting latest offset of %s/%d", topic, partition)
+		}
+		return offset, true, nil
+	}
+}
+
+// AtTimestamp is the offset of the first message with the timestamp equal
+// or later than ts or the latest offset if there's no such messages
+func AtTimestamp(client OffsetResolver, ts time.Time) Position {
+	return func(topic string, partition int32) (int64, bool, error) {
+		offset, err := client.GetOffset(topic, partition, ts.UnixMilli())
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "error getting offset of %s/%d at %s", topic, partition, ts.Format(time.RFC3339))
+		}
+
+		if offset < 0 {
+			return Latest(client)(topic, partition)
+		}
+		return offset, true, nil
+	}
+}
+
+// AtOffsets is the position defined explicitly for the partitions
+func AtOffsets(offsets map[string]map[int32]int64) Position {
+	return func(topic string, partition int32) (int64, bool, error) {
+		offset, ok := offsets[topic][partition]
+		return offset, ok, nil
+	}
+}
+
+// WithStartPosition resets the consumer group offsets to the position on
+// the first assignment of each partition to the service. Committed offsets
+// are used on further rebalances.
+func WithStartPosition(p Position) Option {
+	return func(s *service) {
+		s.replay.start = p
+	}
+}
+
+// WithStopPosition enables bounded replay: each partition is consumed up to
+// the position (exclusive) resolved on its first assignment and Run returns
+// nil once all the assigned partitions reached it. Partitions the position
+// is not defined for are not consumed. Partitions with the high watermark
+// not beyond the position are done as well once all the messages available
+// are consumed since offsets of the transaction control records are never
+// delivered.
+func WithStopPosition(p Position) Option {
+	return func(s *service) {
+		s.replay.stop = p
+	}
+}
+
+// WithOffsetResolver is used by bounded replay to resolve the actual offset
+// of the claims starting from sarama.OffsetOldest or sarama.OffsetNewest
+// (partitions without committed offset). Without it such partitions are
+// done once no messages are delivered for a while.
+func WithOffsetResolver(client OffsetResolver) Option {
+	return func(s *service) {
+		s.replay.resolver = client
+	}
+}
+
+// Replay processes messages of the topics between from and to positions
+// with the handler and returns once done
+func Replay(ctx context.Context, cg sarama.ConsumerGroup, topics []string, handler Handler, from, to Position, opts ...Option) error {
+	opts = append(opts, WithStartPosition(from), WithStopPosition(to))
+	return New(cg, topics, handler, opts...).Run(ctx)
+}
+
+// replay tracks start and stop positions of the partitions
+type replay struct {
+	start        Position
+	stop         Position
+	resolver     OffsetResolver
+	idleInterval time.Duration
+
+	mutex      sync.Mutex
+	started    map[partitionKey]struct{}
+	stops      map[partitionKey]int64
+	assigned   map[partitionKey]struct{}
+	done       map[partitionKey]struct{}
+	completed  bool
+	completeFn func()
+}
+
+func newReplay() *replay {
+	return &replay{
+		idleInterval: replayIdleInterval,
+
+		started:  map[partitionKey]struct{}{},
+		stops:    map[partitionKey]int64{},
+		assigned: map[partitionKey]struct{}{},
+		done:     map[partitionKey]struct{}{},
+	}
+}
+
+// setup resets offsets of the newly assigned partitions and resolves their
+// stop offsets
+func (r *replay) setup(session sarama.ConsumerGroupSession) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.assigned = map[partitionKey]struct{}{}
+
+	for topic, ps := range session.Claims() {
+		for _, p := range ps {
+			k := partitionKey{topic, p}
+			r.assigned[k] = struct{}{}
+
+			if _, ok := r.started[k]; !ok && r.start != nil {
+				offset, ok, err := r.start(topic, p)
+				if err != nil {
+					return errors.Wrap(err, "error resolving start position")
+				}
+
+				if ok {
+					log.WithFields(log.Fields{
+						"component": "ConsumerGroupHandler",
+						"topic":     topic,
+						"partition": p,
+						"offset":    offset,
+					}).Info("resetting partition offset to start position")
+
+					resetOffset(session, topic, p, offset)
+				}
+			}
+			r.started[k] = struct{}{}
+
+			if _, ok := r.stops[k]; !ok && r.stop != nil {
+				offset, ok, err := r.stop(topic, p)
+				if err != nil {
+					return errors.Wrap(err, "error resolving stop position")
+				}
+
+				if ok {
+					r.stops[k] = offset
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// reached reports whether the offset is beyond the partition stop position.
+// Partitions the stop position is not defined for are not replayed at all.
+func (r *replay) reached(topic string, partition int32, offset int64) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stop == nil {
+		return false
+	}
+
+	stop, ok := r.stops[partitionKey{topic, partition}]
+	return !ok || offset >= stop
+}
+
+// caughtUp reports whether all the messages of the partition below the stop
+// position are consumed: the high watermark is not beyond the stop position
+// and the claim either consumed everything up to it or is idle
+func (r *replay) caughtUp(topic string, partition int32, position, highWaterMark int64, idle bool) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stop == nil {
+		return false
+	}
+
+	stop, ok := r.stops[partitionKey{topic, partition}]
+	if !ok || highWaterMark > stop {
+		return !ok
+	}
+	return idle || (position >= 0 && position >= highWaterMark)
+}
+
+// resolve returns the actual offset for sarama.OffsetOldest and
+// sarama.OffsetNewest if the resolver is set or the offset as is
+func (r *replay) resolve(topic string, partition int32, offset int64) int64 {
+	if offset >= 0 || r.resolver == nil {
+		return offset
+	}
+
+	resolved, err := r.resolver.GetOffset(topic, partition, offset)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+			"topic":     topic,
+			"partition": partition,
+			"offset":    offset,
+		}).Warn("error resolving initial offset")
+
+		return offset
+	}
+	return resolved
+}
+
+// idleTicker returns the channel to check idle partitions with bounded
+// replay, nil channel otherwise
+func (r *replay) idleTicker() (<-chan time.Time, func()) {
+	if r.stop == nil {
+		return nil, func() {}
+	}
+
+	t := time.NewTicker(r.idleInterval)
+	return t.C, t.Stop
+}
+
+// finish marks the partition as done and completes the replay once all the
+// assigned partitions are done
+func (r *replay) finish(topic string, partition int32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.done[partitionKey{topic, partition}] = struct{}{}
+
+	for k := range r.assigned {
+		if _, ok := r.done[k]; !ok {
+			return
+		}
+	}
+
+	if !r.completed {
+		log.WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+		}).Info("all partitions reached stop position. Stopping ...")
+
+		r.completed = true
+		if r.completeFn != nil {
+			r.completeFn()
+		}
+	}
+}
+
+// resetOffset moves the partition offset in any direction: sarama
+// MarkOffset moves it forward only while ResetOffset moves it backward only
+func resetOffset(session sarama.ConsumerGroupSession, topic string, partition int32, offset int64) {
+	session.MarkOffset(topic, partition, offset, "")
+	session.ResetOffset(topic, partition, offset, "")
+}
+
+func (r *replay) setCompleteFn(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.completeFn = fn
+}
+
+func (r *replay) isCompleted() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.completed
+}
+
+// finishClaim pauses the partition reached the stop position and waits for
+// the session to end
+func (h *consumerGroupHandler) finishClaim(ctx context.Context, claim sarama.ConsumerGroupClaim) error {
+	log.WithFields(log.Fields{
+		"component": "ConsumerGroupHandler",
+		"topic":     claim.Topic(),
+		"partition": claim.Partition(),
+	}).Debug("partition reached stop position")
+
+	h.flow.hold(claim.Topic(), claim.Partition())
+	defer h.flow.release(claim.Topic(), claim.Partition())
+
+	h.replay.finish(claim.Topic(), claim.Partition())
+
+	select {
+	case <-h.stopCh:
+	case <-ctx.Done():
+	}
+	return nil
+}
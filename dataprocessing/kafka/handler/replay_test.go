@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestReplayTimeWindow(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	now := time.Now()
+
+	cg := fake.NewConsumerGroup()
+	cg.Produce("test-topic", 0,
+		&sarama.ConsumerMessage{Value: []byte("a"), Timestamp: now.Add(-3 * time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("b"), Timestamp: now.Add(-2 * time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("c"), Timestamp: now.Add(-time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("d"), Timestamp: now},
+	)
+	cg.Produce("test-topic", 1,
+		&sarama.ConsumerMessage{Value: []byte("e"), Timestamp: now.Add(-3 * time.Hour)},
+		&sarama.ConsumerMessage{Value: []byte("f"), Timestamp: now.Add(-time.Hour)},
+	)
+
+	h := &recordingHandler{}
+	err := Replay(ctx, cg, []string{"test-topic"}, h,
+		AtTimestamp(cg, now.Add(-2*time.Hour)),
+		AtTimestamp(cg, now.Add(-30*time.Minute)),
+	)
+	r.NoError(err)
+
+	r.ElementsMatch([]string{"b", "c", "f"}, h.values())
+	r.Equal(int64(3), cg.Committed("test-topic", 0))
+	r.Equal(int64(2), cg.Committed("test-topic", 1))
+}
+
+func TestReplayEarliestToLatest(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 2)
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+
+	h := &recordingHandler{}
+	r.NoError(Replay(ctx, cg, []string{"test-topic"}, h, Earliest(cg), Latest(cg)))
+
+	r.Equal([]string{"a", "b", "c"}, h.values())
+	r.Equal(int64(3), cg.Committed("test-topic", 0))
+	r.Equal(int32(1), cg.Generation())
+}
+
+func TestStartPositionAppliedOnce(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c", "d")
+
+	h := &recordingHandler{}
+	svc := New(cg, []string{"test-topic"}, h, WithStartPosition(AtOffsets(map[string]map[int32]int64{
+		"test-topic": {0: 2},
+	})))
+	errCh := runService(ctx, svc)
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 4))
+	r.Equal([]string{"c", "d"}, h.values())
+
+	cg.Rebalance()
+	r.NoError(cg.WaitGeneration(ctx, 2))
+
+	cg.ProduceValues("test-topic", 0, "e")
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 5))
+	r.Equal([]string{"c", "d", "e"}, h.values())
+
+	cancelFn()
+	r.NoError(<-errCh)
+}
+
+func TestStartPositionError(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a")
+
+	failing := Position(func(string, int32) (int64, bool, error) {
+		return 0, false, errors.New("blah")
+	})
+
+	h := &recordingHandler{}
+	err := New(cg, []string{"test-topic"}, h, WithStartPosition(failing)).Run(ctx)
+	r.ErrorContains(err, "error resolving start position: blah")
+	r.Empty(h.values())
+}
+
+func TestReplayPartialStopPosition(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+	cg.ProduceValues("test-topic", 1, "d", "e")
+
+	h := &recordingHandler{}
+	err := Replay(ctx, cg, []string{"test-topic"}, h, Earliest(cg), AtOffsets(map[string]map[int32]int64{
+		"test-topic": {0: 2},
+	}))
+	r.NoError(err)
+
+	r.Equal([]string{"a", "b"}, h.values())
+	r.Equal(int64(2), cg.Committed("test-topic", 0))
+	r.Equal(int64(0), cg.Committed("test-topic", 1))
+}
+
+func TestReplayBackwardFromCommitted(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b", "c")
+
+	svcCtx, svcCancelFn := context.WithCancel(ctx)
+	errCh := runService(svcCtx, New(cg, []string{"test-topic"}, &recordingHandler{}))
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 3))
+	svcCancelFn()
+	r.NoError(<-errCh)
+
+	h := &recordingHandler{}
+	r.NoError(Replay(ctx, cg, []string{"test-topic"}, h, AtOffsets(map[string]map[int32]int64{
+		"test-topic": {0: 1},
+	}), Latest(cg)))
+
+	r.Equal([]string{"b", "c"}, h.values())
+	r.Equal(int64(3), cg.Committed("test-topic", 0))
+}
+
+func TestReplayWithoutCommittedOffset(t *testing.T) {
+	type testCase struct {
+		name          string
+		initialOffset int64
+		resolver      bool
+		expValues     []string
+	}
+
+	tcs := []testCase{
+		{
+			name:          "newest with resolver",
+			initialOffset: sarama.OffsetNewest,
+			resolver:      true,
+			expValues:     []string{},
+		},
+		{
+			name:          "newest without resolver",
+			initialOffset: sarama.OffsetNewest,
+			expValues:     []string{},
+		},
+		{
+			name:          "oldest with resolver",
+			initialOffset: sarama.OffsetOldest,
+			resolver:      true,
+			expValues:     []string{"a", "b"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Second)
+			defer cancelFn()
+
+			cg := fake.NewConsumerGroup()
+			cg.SetInitialOffset(tc.initialOffset)
+			cg.AddTopic("test-topic", 2)
+			cg.ProduceValues("test-topic", 0, "a", "b")
+
+			opts := []Option{WithStopPosition(Latest(cg))}
+			if tc.resolver {
+				opts = append(opts, WithOffsetResolver(cg))
+			}
+
+			h := &recordingHandler{}
+			svc := New(cg, []string{"test-topic"}, h, opts...).(*service)
+
+			// the offset is resolved without waiting for partitions to be idle
+			svc.replay.idleInterval = 50 * time.Millisecond
+			if tc.resolver {
+				svc.replay.idleInterval = time.Hour
+			}
+
+			r.NoError(svc.Run(ctx))
+			r.NoError(ctx.Err())
+			r.Equal(tc.expValues, h.values())
+		})
+	}
+}
+
+func TestReplayTransactionControlRecords(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b")
+	cg.ProduceControlRecord("test-topic", 0)
+
+	h := &recordingHandler{}
+	svc := New(cg, []string{"test-topic"}, h, WithStartPosition(Earliest(cg)), WithStopPosition(Latest(cg))).(*service)
+	svc.replay.idleInterval = 50 * time.Millisecond
+
+	r.NoError(svc.Run(ctx))
+	r.NoError(ctx.Err())
+	r.Equal([]string{"a", "b"}, h.values())
+	r.Equal(int64(2), cg.Committed("test-topic", 0))
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+)
+
+var (
+	_ Handler                 = (*Router)(nil)
+	_ RebalanceHandler        = (*Router)(nil)
+	_ PartitionHandlerFactory = (*Router)(nil)
+	_ Handler                 = (HandlerFunc)(nil)
+
+	ErrNoRoute = errors.New("no route for message")
+)
+
+// HandlerFunc allows to use ordinary functions as Handler
+type HandlerFunc func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return f(ctx, msg)
+}
+
+// Matcher reports whether the message should be passed to the route handler
+type Matcher func(msg *sarama.ConsumerMessage) bool
+
+// MatchTopic matches messages from the topic with exactly the same name
+func MatchTopic(name string) Matcher {
+	return func(msg *sarama.ConsumerMessage) bool {
+		return msg.Topic == name
+	}
+}
+
+// MatchTopicRegexp matches messages from topics which names are matching
+// the regular expression
+func MatchTopicRegexp(re *regexp.Regexp) Matcher {
+	return func(msg *sarama.ConsumerMessage) bool {
+		return re.MatchString(msg.Topic)
+	}
+}
+
+// MatchHeader matches messages having header with the key and exactly
+// the same value
+func MatchHeader(key, value string) Matcher {
+	return func(msg *sarama.ConsumerMessage) bool {
+		for _, h := range msg.Headers {
+			if h != nil && string(h.Key) == key && string(h.Value) == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type route struct {
+	matchers []Matcher
+	handler  Handler
+}
+
+func (r route) match(msg *sarama.ConsumerMessage) bool {
+	for _, m := range r.matchers {
+		if !m(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// Router dispatches messages to the handlers by topic names, topic patterns
+// and header values. Routes are evaluated in the order they were added and
+// the first matching one wins. Messages without a route are passed to the
+// fallback handler if any or rejected with ErrNoRoute.
+//
+// Router forwards rebalance notifications to the route handlers implementing
+// RebalanceHandler and creates per-partition handlers of the route handlers
+// implementing PartitionHandlerFactory.
+type Router struct {
+	mutex    sync.RWMutex
+	routes   []route
+	fallback Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Route adds handler for the messages matching all of the matchers
+func (r *Router) Route(handler Handler, matchers ...Matcher) *Router {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.routes = append(r.routes, route{
+		matchers: matchers,
+		handler:  handler,
+	})
+	return r
+}
+
+// Topic adds handler for the messages from the topic with exact name
+func (r *Router) Topic(name string, handler Handler) *Router {
+	return r.Route(handler, MatchTopic(name))
+}
+
+// TopicRegexp adds handler for the messages from the topics matching
+// the pattern
+func (r *Router) TopicRegexp(pattern string, handler Handler) (*Router, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error compiling topic pattern `%s`", pattern)
+	}
+
+	return r.Route(handler, MatchTopicRegexp(re)), nil
+}
+
+// Fallback sets handler for the messages not matching any route
+func (r *Router) Fallback(handler Handler) *Router {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.fallback = handler
+	return r
+}
+
+func (r *Router) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	h := r.lookup(msg)
+	if h == nil {
+		return errors.Wrapf(ErrNoRoute, "topic `%s`, partition %d, offset %d", msg.Topic, msg.Partition, msg.Offset)
+	}
+
+	return h.Handle(ctx, msg)
+}
+
+func (r *Router) OnAssigned(ctx context.Context, claims map[string][]int32) error {
+	for _, h := range r.handlers() {
+		if rh, ok := h.(RebalanceHandler); ok {
+			if err := rh.OnAssigned(ctx, claims); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OnRevoked notifies all the handlers even if some of them failed, the first
+// error is returned
+func (r *Router) OnRevoked(ctx context.Context, claims map[string][]int32) error {
+	var firstErr error
+	for _, h := range r.handlers() {
+		if rh, ok := h.(RebalanceHandler); ok {
+			if err := rh.OnRevoked(ctx, claims); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// NewPartitionHandler creates router with the same routes where handlers
+// implementing PartitionHandlerFactory are replaced with their partition
+// handlers
+func (r *Router) NewPartitionHandler(ctx context.Context, topic string, partition int32) (PartitionHandler, error) {
+	r.mutex.RLock()
+	routes := append([]route{}, r.routes...)
+	fallback := r.fallback
+	r.mutex.RUnlock()
+
+	pr := &partitionRouter{
+		router: &Router{},
+	}
+
+	created := map[any]Handler{}
+	partitionHandler := func(h Handler) (Handler, error) {
+		f, ok := h.(PartitionHandlerFactory)
+		if !ok {
+			return h, nil
+		}
+
+		// the dynamic value is checked since i.e. the struct holding
+		// HandlerFunc in an interface field panics being used as a map key
+		cacheable := reflect.ValueOf(h).Comparable()
+		if cacheable {
+			if ph, ok := created[h]; ok {
+				return ph, nil
+			}
+		}
+
+		ph, err := f.NewPartitionHandler(ctx, topic, partition)
+		if err != nil {
+			return nil, err
+		}
+		pr.closers = append(pr.closers, ph)
+
+		if cacheable {
+			created[h] = ph
+		}
+		return ph, nil
+	}
+
+	for _, rt := range routes {
+		h, err := partitionHandler(rt.handler)
+		if err != nil {
+			_ = pr.Close()
+			return nil, err
+		}
+		pr.router.routes = append(pr.router.routes, route{
+			matchers: rt.matchers,
+			handler:  h,
+		})
+	}
+
+	if fallback != nil {
+		h, err := partitionHandler(fallback)
+		if err != nil {
+			_ = pr.Close()
+			return nil, err
+		}
+		pr.router.fallback = h
+	}
+
+	return pr, nil
+}
+
+func (r *Router) lookup(msg *sarama.ConsumerMessage) Handler {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, rt := range r.routes {
+		if rt.match(msg) {
+			return rt.handler
+		}
+	}
+	return r.fallback
+}
+
+// handlers returns route and fallback handlers, the handler used by several
+// routes is returned once
+func (r *Router) handlers() []Handler {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	seen := map[any]struct{}{}
+	out := []Handler{}
+	add := func(h Handler) {
+		if h == nil {
+			return
+		}
+
+		if reflect.ValueOf(h).Comparable() {
+			if _, ok := seen[h]; ok {
+				return
+			}
+			seen[h] = struct{}{}
+		}
+		out = append(out, h)
+	}
+
+	for _, rt := range r.routes {
+		add(rt.handler)
+	}
+	add(r.fallback)
+
+	return out
+}
+
+// partitionRouter is the router bound to the claimed partition
+type partitionRouter struct {
+	router  *Router
+	closers []PartitionHandler
+}
+
+func (r *partitionRouter) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return r.router.Handle(ctx, msg)
+}
+
+// Close closes all the partition handlers, the first error is returned
+func (r *partitionRouter) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestRouter(t *testing.T) {
+	type testCase struct {
+		name   string
+		msg    *sarama.ConsumerMessage
+		expTag string
+	}
+
+	var called string
+	tagged := func(tag string) Handler {
+		return HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+			called = tag
+			return nil
+		})
+	}
+
+	newRouter := func(t *testing.T, withFallback bool) *Router {
+		r := NewRouter().
+			Route(tagged("header"), MatchTopic("orders"), MatchHeader("type", "refund")).
+			Topic("orders", tagged("orders"))
+
+		r, err := r.TopicRegexp(`^events\..+$`, tagged("events"))
+		require.NoError(t, err)
+
+		if withFallback {
+			r.Fallback(tagged("fallback"))
+		}
+		return r
+	}
+
+	tcs := []testCase{
+		{
+			name:   "exact topic",
+			msg:    &sarama.ConsumerMessage{Topic: "orders"},
+			expTag: "orders",
+		},
+		{
+			name: "topic and header",
+			msg: &sarama.ConsumerMessage{
+				Topic: "orders",
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte("type"), Value: []byte("refund")},
+				},
+			},
+			expTag: "header",
+		},
+		{
+			name: "header value mismatch",
+			msg: &sarama.ConsumerMessage{
+				Topic: "orders",
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte("type"), Value: []byte("purchase")},
+				},
+			},
+			expTag: "orders",
+		},
+		{
+			name:   "topic pattern",
+			msg:    &sarama.ConsumerMessage{Topic: "events.user"},
+			expTag: "events",
+		},
+		{
+			name:   "fallback",
+			msg:    &sarama.ConsumerMessage{Topic: "unknown"},
+			expTag: "fallback",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			called = ""
+
+			err := newRouter(t, true).Handle(context.TODO(), tc.msg)
+			r.NoError(err)
+			r.Equal(tc.expTag, called)
+		})
+	}
+
+	t.Run("no route", func(t *testing.T) {
+		r := require.New(t)
+		called = ""
+
+		err := newRouter(t, false).Handle(context.TODO(), &sarama.ConsumerMessage{Topic: "unknown"})
+		r.ErrorIs(err, ErrNoRoute)
+		r.Empty(called)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		r := require.New(t)
+
+		_, err := NewRouter().TopicRegexp(`(`, tagged("invalid"))
+		r.Error(err)
+	})
+}
+
+func TestRouterRebalanceHooks(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	h := &statefulHandler{
+		handled:    make(chan Claim, 2),
+		partitions: map[int32]*partitionHandler{},
+	}
+
+	// the same handler on several routes gets the single partition handler
+	router := NewRouter().
+		Route(h, MatchHeader("type", "refund")).
+		Topic("test-topic", h).
+		Fallback(HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+			return nil
+		}))
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a")
+	cg.ProduceValues("test-topic", 1, "b")
+
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, router))
+
+	handled := []Claim{<-h.handled, <-h.handled}
+	cancelFn()
+	r.NoError(<-errCh)
+
+	r.ElementsMatch([]Claim{
+		{Topic: "test-topic", Partition: 0},
+		{Topic: "test-topic", Partition: 1},
+	}, handled)
+
+	r.Equal(map[string][]int32{"test-topic": {0, 1}}, h.assigned)
+	r.Equal(map[string][]int32{"test-topic": {0, 1}}, h.revoked)
+
+	r.Equal(2, h.created)
+	for p, ph := range h.partitions {
+		r.Equal(1, ph.calls, "partition %d", p)
+		r.True(ph.closed, "partition %d", p)
+	}
+}
+
+func TestRouterUncomparableHandlers(t *testing.T) {
+	r := require.New(t)
+
+	calls := 0
+	w := valueWrapper{next: HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	})}
+
+	router := NewRouter().
+		Route(w, MatchHeader("type", "refund")).
+		Topic("test-topic", w)
+
+	claims := map[string][]int32{"test-topic": {0}}
+	r.NoError(router.OnAssigned(context.TODO(), claims))
+	r.NoError(router.OnRevoked(context.TODO(), claims))
+
+	ph, err := router.NewPartitionHandler(context.TODO(), "test-topic", 0)
+	r.NoError(err)
+	r.NoError(ph.Handle(context.TODO(), &sarama.ConsumerMessage{Topic: "test-topic"}))
+	r.NoError(ph.Close())
+	r.Equal(1, calls)
+}
+
+// valueWrapper is comparable by type but not by value holding HandlerFunc
+type valueWrapper struct {
+	next Handler
+}
+
+func (w valueWrapper) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return w.next.Handle(ctx, msg)
+}
+
+func (w valueWrapper) OnAssigned(context.Context, map[string][]int32) error {
+	return nil
+}
+
+func (w valueWrapper) OnRevoked(context.Context, map[string][]int32) error {
+	return nil
+}
+
+func (w valueWrapper) NewPartitionHandler(context.Context, string, int32) (PartitionHandler, error) {
+	return &partitionRouter{router: NewRouter().Fallback(w.next)}, nil
+}
@@ -2,28 +2,129 @@ package handler
 
 import (
 	"context"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
+const defaultHealthInterval = 5 * time.Second
+
 type Service interface {
 	Run(ctx context.Context) error
+
+	// Pause suspends consumption of the partitions until they're resumed.
+	// Paused state survives rebalances.
+	Pause(partitions map[string][]int32)
+	// Resume resumes consumption of the partitions paused with Pause or PauseAll
+	Resume(partitions map[string][]int32)
+	PauseAll()
+	ResumeAll()
+
+	// Liveness, Readiness and Startup are health check functions compatible
+	// with applications/metrics.New
+	Liveness() error
+	Readiness() error
+	Startup() error
+}
+
+type Option func(s *service)
+
+// WithDrainTimeout enables graceful shutdown: once the context passed to Run
+// is cancelled the service stops fetching new messages and lets in-flight
+// handlers complete within the timeout. Offsets of the completed messages
+// are marked and committed before Run returns. Handler context is cancelled
+// only when the timeout is exceeded.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *service) {
+		s.drainTimeout = timeout
+	}
+}
+
+// WithMessageRateLimit limits the amount of messages passed to the handler
+// per second using token bucket with the burst size. Non-positive limit
+// disables the limit, burst is at least 1.
+func WithMessageRateLimit(limit float64, burst int) Option {
+	return func(s *service) {
+		s.flow.messageLimiter = newLimiter(limit, burst)
+	}
+}
+
+// WithByteRateLimit limits the amount of message bytes (key and value) passed
+// to the handler per second using token bucket with the burst size. Messages
+// larger than burst are accounted as burst size. Non-positive limit disables
+// the limit, burst is at least 1.
+func WithByteRateLimit(limit float64, burst int) Option {
+	return func(s *service) {
+		s.flow.byteLimiter = newLimiter(limit, burst)
+	}
+}
+
+// newLimiter returns nil for non-positive limit since such limiter fails
+// each wait
+func newLimiter(limit float64, burst int) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(limit), max(burst, 1))
+}
+
+// WithHealthPause runs the health check function each interval and pauses
+// all the partitions while it returns an error. Compatible with the probe
+// functions used in applications/metrics. Non-positive interval means 5s.
+func WithHealthPause(fn func() error, interval time.Duration) Option {
+	return func(s *service) {
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+
+		s.healthFn = fn
+		s.healthInterval = interval
+	}
+}
+
+// WithErrorPolicy sets the policy applied when the handler returns an error.
+// RestartSession is used by default.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(s *service) {
+		s.errorPolicy = p
+	}
 }
 
 type service struct {
 	cg      sarama.ConsumerGroup
 	topics  []string
 	handler Handler
+	flow    *flowControl
+	health  *health
+	replay  *replay
+
+	errorPolicy    ErrorPolicy
+	notBefore      NotBeforeFunc
+	drainTimeout   time.Duration
+	healthFn       func() error
+	healthInterval time.Duration
 }
 
-func New(cg sarama.ConsumerGroup, topics []string, handler Handler) Service {
-	return &service{
+func New(cg sarama.ConsumerGroup, topics []string, handler Handler, opts ...Option) Service {
+	s := &service{
 		cg:      cg,
 		topics:  topics,
 		handler: handler,
+		flow:    newFlowControl(cg),
+		health:  newHealth(),
+		replay:  newReplay(),
+
+		errorPolicy: RestartSession(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
 func (s *service) Run(ctx context.Context) error {
@@ -31,22 +132,52 @@ func (s *service) Run(ctx context.Context) error {
 		"component": "ConsumerGroupHandler",
 	}).Trace("Run() called")
 
-	cgh := &consumerGroupHandler{
-		handler: s.handler,
+	if s.healthFn != nil {
+		healthCtx, cancelFn := context.WithCancel(ctx)
+		defer cancelFn()
+
+		go s.flow.watchHealth(healthCtx, s.healthFn, s.healthInterval)
 	}
 
-	for {
-		log.WithFields(log.Fields{
-			"component": "ConsumerGroupHandler",
-		}).Trace("starting message consumption")
+	var stopCh <-chan struct{}
+	if s.drainTimeout > 0 {
+		stopCh = ctx.Done()
+	}
+	cgh := newConsumerGroupHandler(s, stopCh)
 
-		if err := s.cg.Consume(ctx, s.topics, cgh); err != nil {
-			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
-				return errors.Wrap(err, "consumer group closed")
+	consumeCtx := ctx
+	if s.drainTimeout > 0 {
+		var cancelFn context.CancelFunc
+		consumeCtx, cancelFn = context.WithCancel(context.WithoutCancel(ctx))
+		defer cancelFn()
+
+		stopDrain := context.AfterFunc(ctx, func() {
+			// the session without claims never ends by itself
+			if cgh.idle() {
+				log.WithFields(log.Fields{
+					"component": "ConsumerGroupHandler",
+				}).Info("context cancelled. No in-flight messages, stopping ...")
+
+				cancelFn()
+				return
 			}
-			return errors.Wrap(err, "error consuming messages")
-		}
 
+			log.WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+				"timeout":   s.drainTimeout.String(),
+			}).Info("context cancelled. Draining in-flight messages ...")
+
+			time.AfterFunc(s.drainTimeout, cancelFn)
+		})
+		defer stopDrain()
+	}
+
+	// consumption is over once bounded replay is completed
+	consumeCtx, cancelConsume := context.WithCancel(consumeCtx)
+	defer cancelConsume()
+	s.replay.setCompleteFn(cancelConsume)
+
+	for {
 		if ctx.Err() != nil {
 			if errors.Is(ctx.Err(), context.Canceled) {
 				log.WithFields(log.Fields{
@@ -57,5 +188,56 @@ func (s *service) Run(ctx context.Context) error {
 			}
 			return errors.Wrap(ctx.Err(), "context error received")
 		}
+
+		log.WithFields(log.Fields{
+			"component": "ConsumerGroupHandler",
+		}).Trace("starting message consumption")
+
+		if err := s.cg.Consume(consumeCtx, s.topics, cgh); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return errors.Wrap(err, "consumer group closed")
+			}
+			return errors.Wrap(err, "error consuming messages")
+		}
+
+		if err := cgh.fatal(); err != nil {
+			return errors.Wrap(err, "service stopped by error policy")
+		}
+
+		if s.replay.isCompleted() {
+			log.WithFields(log.Fields{
+				"component": "ConsumerGroupHandler",
+			}).Info("replay completed")
+
+			return nil
+		}
 	}
 }
+
+func (s *service) Pause(partitions map[string][]int32) {
+	s.flow.Pause(partitions)
+}
+
+func (s *service) Resume(partitions map[string][]int32) {
+	s.flow.Resume(partitions)
+}
+
+func (s *service) PauseAll() {
+	s.flow.PauseAll()
+}
+
+func (s *service) ResumeAll() {
+	s.flow.ResumeAll()
+}
+
+func (s *service) Liveness() error {
+	return s.health.Liveness()
+}
+
+func (s *service) Readiness() error {
+	return s.health.Readiness()
+}
+
+func (s *service) Startup() error {
+	return s.health.Startup()
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+
+	"github.com/teran/go-collection/dataprocessing/kafka/handler/fake"
+)
+
+func TestServiceDrain(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := []string{}
+
+	h := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handled = append(handled, string(msg.Value))
+		close(started)
+		<-release
+		return ctx.Err()
+	})
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b")
+
+	svc := New(cg, []string{"test-topic"}, h, WithDrainTimeout(10*time.Second))
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- svc.Run(ctx)
+	}()
+
+	<-started
+	cancelFn()
+	close(release)
+
+	r.NoError(<-errCh)
+	r.Equal([]string{"a"}, handled)
+	r.Equal(int64(1), cg.Committed("test-topic", 0))
+}
+
+func TestServiceDrainMultiplePartitions(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		close(started)
+		<-release
+		return ctx.Err()
+	})
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 3)
+	cg.ProduceValues("test-topic", 1, "a")
+
+	svc := New(cg, []string{"test-topic"}, h, WithDrainTimeout(10*time.Second))
+	errCh := runService(ctx, svc)
+
+	<-started
+	cancelFn()
+
+	// idle claims must not end the session while the handler is in-flight
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	r.NoError(<-errCh)
+	r.Equal(int64(1), cg.Committed("test-topic", 1))
+}
+
+func TestServiceDrainNoPartitions(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	cg := fake.NewConsumerGroup()
+	cg.AddTopic("test-topic", 1)
+	cg.Assign(map[string][]int32{})
+
+	svc := New(cg, []string{"test-topic"}, &recordingHandler{}, WithDrainTimeout(10*time.Second))
+	errCh := runService(ctx, svc)
+	r.NoError(cg.WaitGeneration(ctx, 1))
+
+	start := time.Now()
+	cancelFn()
+
+	r.NoError(<-errCh)
+	r.Less(time.Since(start), time.Second)
+}
+
+func TestServiceDrainTimeout(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	started := make(chan struct{})
+	h := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a")
+
+	svc := New(cg, []string{"test-topic"}, h, WithDrainTimeout(50*time.Millisecond))
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- svc.Run(ctx)
+	}()
+
+	<-started
+	cancelFn()
+
+	r.NoError(<-errCh)
+	r.Equal(int64(0), cg.Committed("test-topic", 0))
+}
+
+func TestServiceRebalance(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	h := &recordingHandler{}
+
+	cg := fake.NewConsumerGroup()
+	cg.ProduceValues("test-topic", 0, "a", "b")
+	cg.ProduceValues("test-topic", 1, "c")
+
+	errCh := runService(ctx, New(cg, []string{"test-topic"}, h))
+
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 2))
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 1, 1))
+
+	cg.Rebalance()
+	r.NoError(cg.WaitGeneration(ctx, 2))
+
+	cg.ProduceValues("test-topic", 0, "d")
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 0, 3))
+
+	cg.CloseMessages()
+	r.NoError(cg.WaitGeneration(ctx, 3))
+
+	cg.ProduceValues("test-topic", 1, "e")
+	r.NoError(cg.WaitCommitted(ctx, "test-topic", 1, 2))
+
+	cancelFn()
+	r.NoError(<-errCh)
+	r.ElementsMatch([]string{"a", "b", "c", "d", "e"}, h.values())
+}
+
+func TestServiceClosedConsumerGroup(t *testing.T) {
+	r := require.New(t)
+
+	cg := fake.NewConsumerGroup()
+	r.NoError(cg.Close())
+
+	err := New(cg, []string{"test-topic"}, &recordingHandler{}).Run(context.TODO())
+	r.ErrorIs(err, sarama.ErrClosedConsumerGroup)
+}
+
+func TestConsumeClaimClosedMessages(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	svc := New(fake.NewConsumerGroup(), []string{"test-topic"}, &recordingHandler{}).(*service)
+	cgh := newConsumerGroupHandler(svc, make(chan struct{}))
+
+	messages := make(chan *sarama.ConsumerMessage)
+	close(messages)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cgh.ConsumeClaim(
+			&closedSession{ctx: ctx},
+			&closedClaim{messages: messages},
+		)
+	}()
+
+	select {
+	case err := <-errCh:
+		r.NoError(err)
+	case <-time.After(5 * time.Second):
+		r.Fail("ConsumeClaim did not return on closed message channel")
+	}
+}
+
+type closedSession struct {
+	sarama.ConsumerGroupSession
+
+	ctx context.Context
+}
+
+func (s *closedSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *closedSession) Commit() {}
+
+type closedClaim struct {
+	sarama.ConsumerGroupClaim
+
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *closedClaim) Topic() string {
+	return "test-topic"
+}
+
+func (c *closedClaim) Partition() int32 {
+	return 0
+}
+
+func (c *closedClaim) InitialOffset() int64 {
+	return 0
+}
+
+func (c *closedClaim) HighWaterMarkOffset() int64 {
+	return 0
+}
+
+func (c *closedClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func runService(ctx context.Context, svc Service) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- svc.Run(ctx)
+	}()
+	return errCh
+}
+
+type recordingHandler struct {
+	mutex   sync.Mutex
+	handled []string
+}
+
+func (h *recordingHandler) Handle(_ context.Context, msg *sarama.ConsumerMessage) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.handled = append(h.handled, string(msg.Value))
+	return nil
+}
+
+func (h *recordingHandler) values() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]string{}, h.handled...)
+}
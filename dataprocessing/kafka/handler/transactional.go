@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	_ Handler              = (*transactionalHandler)(nil)
+	_ TransactionalHandler = (TransactionalHandlerFunc)(nil)
+	_ Emitter              = (*emitter)(nil)
+
+	ErrNotTransactional = errors.New("producer is not transactional")
+)
+
+// Emitter collects output messages to be produced within the transaction
+type Emitter interface {
+	Emit(msgs ...*sarama.ProducerMessage)
+}
+
+// TransactionalHandler handles the message and emits output messages which
+// are produced only if the handler succeeded
+type TransactionalHandler interface {
+	Handle(ctx context.Context, msg *sarama.ConsumerMessage, emitter Emitter) error
+}
+
+type TransactionalHandlerFunc func(ctx context.Context, msg *sarama.ConsumerMessage, emitter Emitter) error
+
+func (f TransactionalHandlerFunc) Handle(ctx context.Context, msg *sarama.ConsumerMessage, emitter Emitter) error {
+	return f(ctx, msg, emitter)
+}
+
+type emitter struct {
+	msgs []*sarama.ProducerMessage
+}
+
+func (e *emitter) Emit(msgs ...*sarama.ProducerMessage) {
+	e.msgs = append(e.msgs, msgs...)
+}
+
+type transactionalHandler struct {
+	mutex    sync.Mutex
+	producer sarama.SyncProducer
+	groupID  string
+	handler  TransactionalHandler
+}
+
+// NewTransactionalHandler creates Handler for consume-transform-produce
+// pipelines with exactly-once semantics: messages emitted by the handler are
+// produced and the consumed message offset is committed within the single
+// Kafka transaction. The transaction is aborted on any handler error.
+//
+// Producer must be created with Producer.Transaction.ID and
+// Producer.Idempotent set, groupID must be the consumer group name.
+// Downstream consumers must use Consumer.IsolationLevel = ReadCommitted.
+// Transactions are serialized since producer can run one at a time.
+func NewTransactionalHandler(producer sarama.SyncProducer, groupID string, handler TransactionalHandler) (Handler, error) {
+	if !producer.IsTransactional() {
+		return nil, ErrNotTransactional
+	}
+
+	return &transactionalHandler{
+		producer: producer,
+		groupID:  groupID,
+		handler:  handler,
+	}, nil
+}
+
+func (h *transactionalHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := h.producer.BeginTxn(); err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+
+	if err := h.handle(ctx, msg); err != nil {
+		if abortErr := h.producer.AbortTxn(); abortErr != nil {
+			log.WithError(abortErr).WithFields(log.Fields{
+				"component": "TransactionalHandler",
+				"status":    h.producer.TxnStatus().String(),
+			}).Error("error aborting transaction")
+
+			return errors.Wrapf(err, "error aborting transaction: %s", abortErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (h *transactionalHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	e := &emitter{}
+	if err := h.handler.Handle(ctx, msg, e); err != nil {
+		return err
+	}
+
+	if len(e.msgs) > 0 {
+		if err := h.producer.SendMessages(e.msgs); err != nil {
+			return errors.Wrap(err, "error producing messages")
+		}
+	}
+
+	offsets := map[string][]*sarama.PartitionOffsetMetadata{
+		msg.Topic: {{Partition: msg.Partition, Offset: msg.Offset + 1}},
+	}
+	if err := h.producer.AddOffsetsToTxn(offsets, h.groupID); err != nil {
+		return errors.Wrap(err, "error adding offsets to transaction")
+	}
+
+	if err := h.producer.CommitTxn(); err != nil {
+		return errors.Wrap(err, "error committing transaction")
+	}
+
+	return nil
+}
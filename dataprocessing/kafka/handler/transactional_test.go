@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionalHandler(t *testing.T) {
+	type testCase struct {
+		name      string
+		handlerFn TransactionalHandlerFunc
+		sendErr   error
+		expErr    bool
+		expCalls  []string
+		expSent   int
+	}
+
+	emitTwo := func(_ context.Context, msg *sarama.ConsumerMessage, e Emitter) error {
+		e.Emit(
+			&sarama.ProducerMessage{Topic: "out", Value: sarama.ByteEncoder(msg.Value)},
+			&sarama.ProducerMessage{Topic: "out", Value: sarama.ByteEncoder(msg.Value)},
+		)
+		return nil
+	}
+
+	tcs := []testCase{
+		{
+			name:      "commit",
+			handlerFn: emitTwo,
+			expCalls:  []string{"BeginTxn", "SendMessages", "AddOffsetsToTxn:in/3/11", "CommitTxn"},
+			expSent:   2,
+		},
+		{
+			name: "commit without output",
+			handlerFn: func(context.Context, *sarama.ConsumerMessage, Emitter) error {
+				return nil
+			},
+			expCalls: []string{"BeginTxn", "AddOffsetsToTxn:in/3/11", "CommitTxn"},
+		},
+		{
+			name: "handler error",
+			handlerFn: func(_ context.Context, _ *sarama.ConsumerMessage, e Emitter) error {
+				e.Emit(&sarama.ProducerMessage{Topic: "out"})
+				return errors.New("blah")
+			},
+			expErr:   true,
+			expCalls: []string{"BeginTxn", "AbortTxn"},
+		},
+		{
+			name:      "produce error",
+			handlerFn: emitTwo,
+			sendErr:   errors.New("blah"),
+			expErr:    true,
+			expCalls:  []string{"BeginTxn", "SendMessages", "AbortTxn"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			p := &stubTxnProducer{transactional: true, sendErr: tc.sendErr}
+			h, err := NewTransactionalHandler(p, "test-group", tc.handlerFn)
+			r.NoError(err)
+
+			err = h.Handle(context.TODO(), &sarama.ConsumerMessage{
+				Topic:     "in",
+				Partition: 3,
+				Offset:    10,
+				Value:     []byte("test"),
+			})
+			if tc.expErr {
+				r.Error(err)
+			} else {
+				r.NoError(err)
+			}
+			r.Equal(tc.expCalls, p.calls)
+			r.Len(p.sent, tc.expSent)
+		})
+	}
+}
+
+func TestTransactionalHandlerNotTransactional(t *testing.T) {
+	r := require.New(t)
+
+	_, err := NewTransactionalHandler(&stubTxnProducer{}, "test-group", nil)
+	r.ErrorIs(err, ErrNotTransactional)
+}
+
+type stubTxnProducer struct {
+	sarama.SyncProducer
+
+	transactional bool
+	sendErr       error
+	calls         []string
+	sent          []*sarama.ProducerMessage
+}
+
+func (p *stubTxnProducer) IsTransactional() bool { return p.transactional }
+
+func (p *stubTxnProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *stubTxnProducer) BeginTxn() error {
+	p.calls = append(p.calls, "BeginTxn")
+	return nil
+}
+
+func (p *stubTxnProducer) CommitTxn() error {
+	p.calls = append(p.calls, "CommitTxn")
+	return nil
+}
+
+func (p *stubTxnProducer) AbortTxn() error {
+	p.calls = append(p.calls, "AbortTxn")
+	return nil
+}
+
+func (p *stubTxnProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.calls = append(p.calls, "SendMessages")
+	if p.sendErr != nil {
+		return p.sendErr
+	}
+
+	p.sent = append(p.sent, msgs...)
+	return nil
+}
+
+func (p *stubTxnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, _ string) error {
+	for topic, os := range offsets {
+		for _, o := range os {
+			p.calls = append(p.calls, fmt.Sprintf("AddOffsetsToTxn:%s/%d/%d", topic, o.Partition, o.Offset))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+)
+
+// asyncMetadata wraps user metadata to track the delivery duration
+type asyncMetadata struct {
+	metadata any
+	start    time.Time
+}
+
+type asyncProducer struct {
+	producer sarama.AsyncProducer
+	opts     *options
+	metrics  *metrics
+
+	// pending tracks Produce calls in progress since nothing could be sent
+	// to the producer input once AsyncClose is called
+	mutex   sync.Mutex
+	closed  bool
+	closeCh chan struct{}
+	pending sync.WaitGroup
+	doneCh  chan struct{}
+}
+
+// NewAsync creates producer delivering the results to the callbacks.
+// Both Successes and Errors channels of the producer are drained by
+// the wrapper so they must not be read by anyone else.
+func NewAsync(p sarama.AsyncProducer, opts ...Option) (Producer, error) {
+	o, m, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ap := &asyncProducer{
+		producer: p,
+		opts:     o,
+		metrics:  m,
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	go ap.dispatch()
+
+	return ap, nil
+}
+
+func (p *asyncProducer) Produce(ctx context.Context, msg *sarama.ProducerMessage) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrClosed
+	}
+	p.pending.Add(1)
+	p.mutex.Unlock()
+
+	defer p.pending.Done()
+
+	msg.Metadata = &asyncMetadata{
+		metadata: msg.Metadata,
+		start:    time.Now(),
+	}
+
+	select {
+	case p.producer.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		msg.Metadata = msg.Metadata.(*asyncMetadata).metadata
+		return errors.Wrap(ctx.Err(), "error enqueueing message")
+	case <-p.closeCh:
+		msg.Metadata = msg.Metadata.(*asyncMetadata).metadata
+		return ErrClosed
+	}
+}
+
+func (p *asyncProducer) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrClosed
+	}
+	p.closed = true
+	close(p.closeCh)
+	p.mutex.Unlock()
+
+	// blocked Produce calls return once closeCh is closed
+	p.pending.Wait()
+	p.producer.AsyncClose()
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error waiting for pending messages flush")
+	}
+}
+
+// dispatch runs callbacks until both result channels are closed which
+// happens once the producer shutdown is completed
+func (p *asyncProducer) dispatch() {
+	defer close(p.doneCh)
+
+	successes := p.producer.Successes()
+	errs := p.producer.Errors()
+
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			p.metrics.observe(msg.Topic, p.unwrap(msg), nil)
+			if p.opts.onSuccess != nil {
+				p.opts.onSuccess(msg)
+			}
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			p.metrics.observe(perr.Msg.Topic, p.unwrap(perr.Msg), perr.Err)
+			if p.opts.onError != nil {
+				p.opts.onError(perr)
+			}
+		}
+	}
+}
+
+// unwrap restores user metadata and returns the delivery duration
+func (p *asyncProducer) unwrap(msg *sarama.ProducerMessage) time.Duration {
+	md, ok := msg.Metadata.(*asyncMetadata)
+	if !ok {
+		return 0
+	}
+
+	msg.Metadata = md.metadata
+	return time.Since(md.start)
+}
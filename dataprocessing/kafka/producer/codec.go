@@ -0,0 +1,70 @@
+package producer
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ Codec[any]    = jsonCodec[any]{}
+	_ Codec[string] = stringCodec{}
+	_ Codec[[]byte] = bytesCodec{}
+)
+
+// Codec encodes values of type T to message payload
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	// ContentType returns value for the content-type message header,
+	// empty string means no header is set
+	ContentType() string
+}
+
+type jsonCodec[T any] struct{}
+
+// JSON encodes values with encoding/json
+func JSON[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding JSON")
+	}
+	return data, nil
+}
+
+func (jsonCodec[T]) ContentType() string {
+	return "application/json"
+}
+
+type stringCodec struct{}
+
+// String passes strings as is
+func String() Codec[string] {
+	return stringCodec{}
+}
+
+func (stringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+type bytesCodec struct{}
+
+// Bytes passes byte slices as is
+func Bytes() Codec[[]byte] {
+	return bytesCodec{}
+}
+
+func (bytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) ContentType() string {
+	return ""
+}
@@ -0,0 +1,92 @@
+package producer
+
+import (
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const ContentTypeHeader = "content-type"
+
+type MessageOption func(msg *sarama.ProducerMessage)
+
+// WithKey sets the message key. Messages with the same key are put to
+// the same partition by the default sarama hash partitioner.
+func WithKey(key string) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		msg.Key = sarama.StringEncoder(key)
+	}
+}
+
+// WithPartition sets the message partition explicitly. Requires producer
+// configured with sarama.NewManualPartitioner.
+func WithPartition(partition int32) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		msg.Partition = partition
+	}
+}
+
+// WithHeader sets the message header replacing the existing one with
+// the same key
+func WithHeader(key, value string) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		for i, h := range msg.Headers {
+			if string(h.Key) == key {
+				msg.Headers[i].Value = []byte(value)
+				return
+			}
+		}
+
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{
+			Key:   []byte(key),
+			Value: []byte(value),
+		})
+	}
+}
+
+// WithHeaders sets the message headers
+func WithHeaders(headers map[string]string) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		for k, v := range headers {
+			WithHeader(k, v)(msg)
+		}
+	}
+}
+
+func WithTimestamp(ts time.Time) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		msg.Timestamp = ts
+	}
+}
+
+// WithMetadata sets the metadata passed back to the delivery callbacks of
+// the asynchronous producer
+func WithMetadata(metadata any) MessageOption {
+	return func(msg *sarama.ProducerMessage) {
+		msg.Metadata = metadata
+	}
+}
+
+// Header returns the value of the consumed message header
+func Header(msg *sarama.ConsumerMessage, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// NewMessage creates the message to the topic with the payload
+func NewMessage(topic string, payload []byte, opts ...MessageOption) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(payload),
+	}
+
+	for _, opt := range opts {
+		opt(msg)
+	}
+
+	return msg
+}
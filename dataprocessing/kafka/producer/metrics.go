@@ -0,0 +1,71 @@
+package producer
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	messages *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics(registerer prometheus.Registerer) (*metrics, error) {
+	if registerer == nil {
+		return nil, nil
+	}
+
+	m := &metrics{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_producer_messages_total",
+			Help: "Total amount of produced messages by topic and status",
+		}, []string{"topic", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kafka_producer_send_duration_seconds",
+			Help:    "Duration of message delivery by topic",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"topic"}),
+	}
+
+	var err error
+	if m.messages, err = register(registerer, m.messages); err != nil {
+		return nil, err
+	}
+
+	if m.duration, err = register(registerer, m.duration); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *metrics) observe(topic string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	m.messages.WithLabelValues(topic, status).Inc()
+	m.duration.WithLabelValues(topic).Observe(d.Seconds())
+}
+
+// register registers the collector or reuses already registered one so
+// multiple producers could share the same registerer
+func register[T prometheus.Collector](registerer prometheus.Registerer, c T) (T, error) {
+	if err := registerer.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return c, errors.Wrap(err, "error registering metric")
+	}
+	return c, nil
+}
@@ -0,0 +1,139 @@
+// Package producer implements Kafka producer wrappers sharing the ergonomics
+// of the dataprocessing/kafka/handler consumer side
+package producer
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ErrClosed = errors.New("producer is closed")
+
+type Producer interface {
+	// Produce sends the message. Synchronous producer returns once the
+	// message is acknowledged, asynchronous one once it's enqueued: delivery
+	// result is passed to the callbacks then.
+	Produce(ctx context.Context, msg *sarama.ProducerMessage) error
+
+	// Close flushes pending messages and closes the underlying producer.
+	// It gives up waiting for the flush once the context is done.
+	Close(ctx context.Context) error
+}
+
+type Option func(o *options)
+
+type options struct {
+	onSuccess  func(msg *sarama.ProducerMessage)
+	onError    func(err *sarama.ProducerError)
+	registerer prometheus.Registerer
+}
+
+// WithSuccessCallback sets the function called on every delivered message.
+// Asynchronous producer requires Producer.Return.Successes to be enabled.
+func WithSuccessCallback(fn func(msg *sarama.ProducerMessage)) Option {
+	return func(o *options) {
+		o.onSuccess = fn
+	}
+}
+
+// WithErrorCallback sets the function called on every failed message.
+// Asynchronous producer requires Producer.Return.Errors to be enabled.
+func WithErrorCallback(fn func(err *sarama.ProducerError)) Option {
+	return func(o *options) {
+		o.onError = fn
+	}
+}
+
+// WithMetrics registers Prometheus metrics of the producer
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = registerer
+	}
+}
+
+func newOptions(opts []Option) (*options, *metrics, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	m, err := newMetrics(o.registerer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return o, m, nil
+}
+
+// Send encodes the value with the codec and sends it to the topic
+func Send[T any](ctx context.Context, p Producer, codec Codec[T], topic string, v T, opts ...MessageOption) error {
+	payload, err := codec.Encode(v)
+	if err != nil {
+		return errors.Wrap(err, "error encoding message")
+	}
+
+	if ct := codec.ContentType(); ct != "" {
+		opts = append([]MessageOption{WithHeader(ContentTypeHeader, ct)}, opts...)
+	}
+
+	return p.Produce(ctx, NewMessage(topic, payload, opts...))
+}
+
+type syncProducer struct {
+	producer sarama.SyncProducer
+	opts     *options
+	metrics  *metrics
+}
+
+func NewSync(p sarama.SyncProducer, opts ...Option) (Producer, error) {
+	o, m, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncProducer{
+		producer: p,
+		opts:     o,
+		metrics:  m,
+	}, nil
+}
+
+func (p *syncProducer) Produce(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, _, err := p.producer.SendMessage(msg)
+	p.metrics.observe(msg.Topic, time.Since(start), err)
+
+	if err != nil {
+		if p.opts.onError != nil {
+			p.opts.onError(&sarama.ProducerError{Msg: msg, Err: err})
+		}
+		return errors.Wrapf(err, "error sending message to topic `%s`", msg.Topic)
+	}
+
+	if p.opts.onSuccess != nil {
+		p.opts.onSuccess(msg)
+	}
+	return nil
+}
+
+func (p *syncProducer) Close(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.producer.Close()
+	}()
+
+	select {
+	case err := <-errCh:
+		return errors.Wrap(err, "error closing producer")
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error waiting for producer to close")
+	}
+}
@@ -0,0 +1,188 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestSyncSend(t *testing.T) {
+	r := require.New(t)
+
+	mp := mocks.NewSyncProducer(t, nil)
+	defer func() { r.NoError(mp.Close()) }()
+
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		value, err := msg.Value.Encode()
+		if err != nil {
+			return err
+		}
+		if string(value) != `{"id":1,"name":"test"}` {
+			return errors.Errorf("unexpected value: %s", value)
+		}
+
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return err
+		}
+		if string(key) != "user-1" {
+			return errors.Errorf("unexpected key: %s", key)
+		}
+
+		if len(msg.Headers) != 2 || string(msg.Headers[0].Value) != "application/json" || string(msg.Headers[1].Value) != "req-1" {
+			return errors.Errorf("unexpected headers: %#v", msg.Headers)
+		}
+		return nil
+	})
+	mp.ExpectSendMessageAndFail(errors.New("blah"))
+
+	reg := prometheus.NewPedanticRegistry()
+
+	failed := 0
+	p, err := NewSync(mp,
+		WithMetrics(reg),
+		WithErrorCallback(func(*sarama.ProducerError) { failed++ }),
+	)
+	r.NoError(err)
+
+	err = Send(context.TODO(), p, JSON[testEvent](), "events", testEvent{ID: 1, Name: "test"},
+		WithKey("user-1"),
+		WithHeader("x-request-id", "req-1"),
+	)
+	r.NoError(err)
+
+	err = Send(context.TODO(), p, String(), "events", "test")
+	r.Error(err)
+	r.Equal(1, failed)
+
+	r.Equal(float64(1), testutil.ToFloat64(p.(*syncProducer).metrics.messages.WithLabelValues("events", "success")))
+	r.Equal(float64(1), testutil.ToFloat64(p.(*syncProducer).metrics.messages.WithLabelValues("events", "error")))
+
+	// metrics are shared between producers using the same registerer
+	_, err = NewSync(mp, WithMetrics(reg))
+	r.NoError(err)
+}
+
+func TestAsyncSendAndFlush(t *testing.T) {
+	r := require.New(t)
+
+	cfg := mocks.NewTestConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+
+	mp := mocks.NewAsyncProducer(t, cfg)
+	mp.ExpectInputAndSucceed()
+	mp.ExpectInputAndFail(errors.New("blah"))
+
+	delivered := []any{}
+	failed := []any{}
+	p, err := NewAsync(mp,
+		WithSuccessCallback(func(msg *sarama.ProducerMessage) { delivered = append(delivered, msg.Metadata) }),
+		WithErrorCallback(func(perr *sarama.ProducerError) { failed = append(failed, perr.Msg.Metadata) }),
+	)
+	r.NoError(err)
+
+	r.NoError(Send(context.TODO(), p, Bytes(), "events", []byte("one"), WithMetadata("one")))
+	r.NoError(Send(context.TODO(), p, Bytes(), "events", []byte("two"), WithMetadata("two")))
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancelFn()
+
+	r.NoError(p.Close(ctx))
+	r.Equal([]any{"one"}, delivered)
+	r.Equal([]any{"two"}, failed)
+
+	r.ErrorIs(p.Produce(context.TODO(), NewMessage("events", nil)), ErrClosed)
+}
+
+func TestAsyncCloseWithBlockedProduce(t *testing.T) {
+	r := require.New(t)
+
+	bp := newBlockedProducer()
+	p, err := NewAsync(bp)
+	r.NoError(err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Produce(context.Background(), NewMessage("events", nil))
+	}()
+
+	// let Produce to block on the input
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancelFn()
+
+	r.NoError(p.Close(ctx))
+	r.ErrorIs(<-errCh, ErrClosed)
+	r.NoError(ctx.Err())
+}
+
+// blockedProducer never accepts messages the same way sarama does while
+// the brokers are unavailable
+type blockedProducer struct {
+	sarama.AsyncProducer
+
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newBlockedProducer() *blockedProducer {
+	return &blockedProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (p *blockedProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *blockedProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *blockedProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *blockedProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func TestHeaderOptions(t *testing.T) {
+	r := require.New(t)
+
+	msg := NewMessage("events", []byte("test"),
+		WithHeader("a", "1"),
+		WithHeaders(map[string]string{"a": "2", "b": "3"}),
+	)
+	r.ElementsMatch([]sarama.RecordHeader{
+		{Key: []byte("a"), Value: []byte("2")},
+		{Key: []byte("b"), Value: []byte("3")},
+	}, msg.Headers)
+
+	v, ok := Header(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("a"), Value: []byte("1")},
+	}}, "a")
+	r.True(ok)
+	r.Equal("1", v)
+
+	_, ok = Header(&sarama.ConsumerMessage{}, "a")
+	r.False(ok)
+}
@@ -0,0 +1,323 @@
+package multiwriter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ MultiWriter = (*asyncWriter)(nil)
+
+	ErrQueueFull = errors.New("queue is full")
+)
+
+// QueueFullPolicy defines the behaviour of the asynchronous Write once
+// the writer queue is full
+type QueueFullPolicy int
+
+const (
+	// Block waits for the queue to have free space or the context to be done
+	Block QueueFullPolicy = iota
+	// Drop skips the write for the writer
+	Drop
+	// Fail fails the write for the writer with ErrQueueFull applying the
+	// writer policy
+	Fail
+)
+
+// WithAsync makes writes asynchronous: each writer gets its own queue of
+// queueSize writes and the goroutine writing them. Failures of the writes
+// are reported by the subsequent call, Close must be called to drain the
+// queues and stop the goroutines.
+func WithAsync(queueSize int) Option {
+	return func(mw *writer) {
+		mw.queueSize = queueSize
+	}
+}
+
+// WithQueueFullPolicy sets the behaviour once the writer queue is full,
+// Block by default. New fails if it's used without WithAsync
+func WithQueueFullPolicy(policy QueueFullPolicy) Option {
+	return func(mw *writer) {
+		mw.queuePolicy = policy
+		mw.queuePolicySet = true
+	}
+}
+
+// WithQueueSize overrides the queue size set by WithAsync for the writer,
+// New fails if it's used without WithAsync
+func WithQueueSize(n int) WriterOption {
+	return func(s *sink) {
+		s.queueSize = n
+	}
+}
+
+type asyncItem struct {
+	data []byte
+
+	// control function, i.e. Flush or Sync called in order with writes
+	fn     func() error
+	result chan error
+}
+
+type asyncSink struct {
+	*sink
+
+	queue   chan asyncItem
+	done    chan struct{}
+	removed atomic.Bool
+
+	mutex sync.Mutex
+	errs  []*WriterError
+}
+
+type asyncWriter struct {
+	ctx      context.Context
+	onRemove func(idx int, err error)
+	policy   QueueFullPolicy
+
+	mutex  sync.RWMutex
+	sinks  []*asyncSink
+	closed bool
+}
+
+func newAsyncWriter(mw *writer) *asyncWriter {
+	aw := &asyncWriter{
+		ctx:      mw.ctx,
+		onRemove: mw.onRemove,
+		policy:   mw.queuePolicy,
+	}
+
+	for _, s := range mw.all {
+		size := mw.queueSize
+		if s.queueSize > 0 {
+			size = s.queueSize
+		}
+
+		as := &asyncSink{
+			sink:  s,
+			queue: make(chan asyncItem, size),
+			done:  make(chan struct{}),
+		}
+		aw.sinks = append(aw.sinks, as)
+
+		go aw.run(as)
+	}
+
+	return aw
+}
+
+// Write copies p and enqueues it to all the writers
+func (aw *asyncWriter) Write(p []byte) (n int, err error) {
+	aw.mutex.RLock()
+	defer aw.mutex.RUnlock()
+
+	if aw.closed {
+		return 0, ErrClosed
+	}
+
+	// the copy is shared since writers must not modify the data
+	data := bytes.Clone(p)
+
+	for _, s := range aw.sinks {
+		if s.removed.Load() {
+			continue
+		}
+
+		if err := aw.enqueue(s, data); err != nil {
+			aw.fail(s, opWrite, err)
+		}
+	}
+
+	return len(p), aw.pending()
+}
+
+// Close waits for all the queued writes to complete and closes the writers
+// implementing io.Closer including removed ones
+func (aw *asyncWriter) Close() error {
+	aw.mutex.Lock()
+	if aw.closed {
+		aw.mutex.Unlock()
+		return ErrClosed
+	}
+	aw.closed = true
+	aw.mutex.Unlock()
+
+	for _, s := range aw.sinks {
+		close(s.queue)
+	}
+
+	for _, s := range aw.sinks {
+		<-s.done
+	}
+
+	return aw.pending()
+}
+
+// Flush waits for the writes queued before the call and flushes the writers
+func (aw *asyncWriter) Flush() error {
+	return aw.control(opFlush, func(s *sink) error {
+		if f, ok := s.w.(flusher); ok {
+			return f.Flush()
+		}
+		return nil
+	})
+}
+
+// Sync waits for the writes queued before the call and syncs the writers
+func (aw *asyncWriter) Sync() error {
+	return aw.control(opSync, func(s *sink) error {
+		if sc, ok := s.w.(syncer); ok {
+			return sc.Sync()
+		}
+		return nil
+	})
+}
+
+func (aw *asyncWriter) control(op string, fn func(s *sink) error) error {
+	aw.mutex.RLock()
+	defer aw.mutex.RUnlock()
+
+	if aw.closed {
+		return ErrClosed
+	}
+
+	results := make([]chan error, len(aw.sinks))
+	for i, s := range aw.sinks {
+		if s.removed.Load() {
+			continue
+		}
+
+		item := asyncItem{
+			fn:     func() error { return fn(s.sink) },
+			result: make(chan error, 1),
+		}
+
+		// control calls are never dropped regardless of the policy
+		select {
+		case s.queue <- item:
+			results[i] = item.result
+		case <-aw.ctx.Done():
+			aw.fail(s, op, errors.Wrap(aw.ctx.Err(), "error enqueueing"))
+		}
+	}
+
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+
+		if err := <-result; err != nil {
+			aw.fail(aw.sinks[i], op, err)
+		}
+	}
+
+	return aw.pending()
+}
+
+func (aw *asyncWriter) enqueue(s *asyncSink, data []byte) error {
+	item := asyncItem{data: data}
+
+	switch aw.policy {
+	case Drop:
+		select {
+		case s.queue <- item:
+		default:
+		}
+		return nil
+	case Fail:
+		select {
+		case s.queue <- item:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	default:
+		select {
+		case s.queue <- item:
+			return nil
+		case <-aw.ctx.Done():
+			return errors.Wrap(aw.ctx.Err(), "error enqueueing")
+		}
+	}
+}
+
+// run writes the queued data until the queue is closed, then closes the
+// writer
+func (aw *asyncWriter) run(s *asyncSink) {
+	defer close(s.done)
+
+	for item := range s.queue {
+		if item.fn != nil {
+			item.result <- item.fn()
+			continue
+		}
+
+		// the data queued before the removal is discarded
+		if s.removed.Load() {
+			continue
+		}
+
+		if err := s.write(item.data); err != nil {
+			aw.fail(s, opWrite, err)
+		}
+	}
+
+	if c, ok := s.w.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.record(opClose, err)
+		}
+	}
+}
+
+// fail records the error to be reported and removes best-effort writer
+func (aw *asyncWriter) fail(s *asyncSink, op string, err error) {
+	s.record(op, err)
+
+	if s.bestEffort && s.removed.CompareAndSwap(false, true) && aw.onRemove != nil {
+		aw.onRemove(s.idx, err)
+	}
+}
+
+// pending returns the errors recorded since the previous call applying
+// writer policies
+func (aw *asyncWriter) pending() error {
+	werr := &WriteError{}
+	failed := false
+
+	for _, s := range aw.sinks {
+		s.mutex.Lock()
+		errs := s.errs
+		s.errs = nil
+		s.mutex.Unlock()
+
+		for _, err := range errs {
+			werr.Errors = append(werr.Errors, err)
+			if !err.BestEffort {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return werr
+	}
+	return nil
+}
+
+func (s *asyncSink) record(op string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errs = append(s.errs, &WriterError{
+		Index:      s.idx,
+		Op:         op,
+		BestEffort: s.bestEffort,
+		Err:        err,
+	})
+}
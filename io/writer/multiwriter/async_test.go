@@ -0,0 +1,292 @@
+package multiwriter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (s *multiWriterTestSuite) TestAsyncWrite() {
+	buffers := []*bytes.Buffer{{}, {}, {}}
+
+	w, err := New(context.TODO(),
+		WithWriter(buffers[0]),
+		WithWriter(buffers[1]),
+		WithWriter(buffers[2], BestEffort()),
+		WithAsync(4),
+	)
+	s.Require().NoError(err)
+
+	expected := &bytes.Buffer{}
+	p := make([]byte, 0, 16)
+	for i := range 100 {
+		p = strconv.AppendInt(p[:0], int64(i), 10)
+		expected.Write(p)
+
+		n, err := w.Write(p)
+		s.Require().NoError(err)
+		s.Require().Equal(len(p), n)
+	}
+
+	s.Require().NoError(w.Close())
+
+	for _, buf := range buffers {
+		s.Require().Equal(expected.String(), buf.String())
+	}
+
+	_, err = w.Write([]byte("data"))
+	s.Require().ErrorIs(err, ErrClosed)
+	s.Require().ErrorIs(w.Flush(), ErrClosed)
+	s.Require().ErrorIs(w.Close(), ErrClosed)
+}
+
+func (s *multiWriterTestSuite) TestAsyncDrop() {
+	fast := &bytes.Buffer{}
+	slow := newGateWriter()
+
+	w, err := New(context.TODO(),
+		WithWriter(fast, WithQueueSize(8)),
+		WithWriter(slow),
+		WithAsync(1),
+		WithQueueFullPolicy(Drop),
+	)
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("1"))
+	s.Require().NoError(err)
+	<-slow.started
+
+	// the first write is in progress, the second one is queued and the rest
+	// are dropped for the slow writer only
+	for _, v := range []string{"2", "3", "4"} {
+		_, err = w.Write([]byte(v))
+		s.Require().NoError(err)
+	}
+
+	close(slow.gate)
+	s.Require().NoError(w.Close())
+
+	s.Require().Equal("1234", fast.String())
+	s.Require().Equal("12", slow.buf.String())
+}
+
+func (s *multiWriterTestSuite) TestAsyncFail() {
+	required := newGateWriter()
+	bestEffort := newGateWriter()
+
+	removed := make(chan int, 1)
+	w, err := New(context.TODO(),
+		WithWriter(required),
+		WithWriter(bestEffort, BestEffort()),
+		WithAsync(1),
+		WithQueueFullPolicy(Fail),
+		WithRemoveCallback(func(idx int, err error) {
+			removed <- idx
+		}),
+	)
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("1"))
+	s.Require().NoError(err)
+	<-required.started
+	<-bestEffort.started
+
+	_, err = w.Write([]byte("2"))
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("3"))
+	s.Require().ErrorIs(err, ErrQueueFull)
+	s.Require().Equal("2 writer(s) failed: error writing to channel #0: queue is full; error writing to channel #1: queue is full", err.Error())
+	s.Require().Equal(1, <-removed)
+
+	close(required.gate)
+	close(bestEffort.gate)
+	s.Require().NoError(w.Close())
+
+	s.Require().Equal("12", required.buf.String())
+	s.Require().Equal("1", bestEffort.buf.String())
+}
+
+func (s *multiWriterTestSuite) TestAsyncBlock() {
+	slow := newGateWriter()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	w, err := New(ctx, WithWriter(slow), WithAsync(1))
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("1"))
+	s.Require().NoError(err)
+	<-slow.started
+
+	_, err = w.Write([]byte("2"))
+	s.Require().NoError(err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("3"))
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		s.FailNow("write is expected to block", "got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	s.Require().ErrorIs(<-errCh, context.Canceled)
+
+	close(slow.gate)
+	s.Require().NoError(w.Close())
+	s.Require().Equal("12", slow.buf.String())
+}
+
+func (s *multiWriterTestSuite) TestAsyncErrors() {
+	required := &failingWriter{failures: 1}
+	bestEffort := &failingWriter{failures: -1}
+
+	removed := make(chan int, 1)
+	w, err := New(context.TODO(),
+		WithWriter(required),
+		WithWriter(bestEffort, BestEffort()),
+		WithAsync(4),
+		WithRemoveCallback(func(idx int, err error) {
+			removed <- idx
+		}),
+	)
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("first"))
+	s.Require().NoError(err)
+
+	// the failure is reported by the subsequent call once
+	err = w.Flush()
+	s.Require().ErrorIs(err, errWriteFailed)
+
+	werr := &WriteError{}
+	s.Require().ErrorAs(err, &werr)
+	s.Require().Equal([]int{0, 1}, werr.Indexes())
+	s.Require().Equal(1, <-removed)
+
+	_, err = w.Write([]byte("second"))
+	s.Require().NoError(err)
+	s.Require().NoError(w.Close())
+
+	s.Require().Equal("second", required.buf.String())
+	s.Require().Equal(1, bestEffort.calls)
+}
+
+func (s *multiWriterTestSuite) TestAsyncFlushSyncClose() {
+	f := &orderedFile{}
+
+	w, err := New(context.TODO(), WithWriter(f), WithAsync(8))
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("a"))
+	s.Require().NoError(err)
+	s.Require().NoError(w.Flush())
+
+	_, err = w.Write([]byte("b"))
+	s.Require().NoError(err)
+	s.Require().NoError(w.Sync())
+
+	_, err = w.Write([]byte("c"))
+	s.Require().NoError(err)
+	s.Require().NoError(w.Close())
+
+	s.Require().Equal([]string{"write a", "flush", "write b", "sync", "write c", "close"}, f.calls)
+}
+
+func (s *multiWriterTestSuite) TestAsyncCloseError() {
+	f := &fakeFile{closeErr: errors.New("close failed")}
+
+	w, err := New(context.TODO(), WithWriter(f), WithAsync(1))
+	s.Require().NoError(err)
+
+	err = w.Close()
+	s.Require().Error(err)
+	s.Require().Equal("1 writer(s) failed: error closing channel #0: close failed", err.Error())
+	s.Require().True(f.closed)
+}
+
+func (s *multiWriterTestSuite) TestAsyncValidation() {
+	_, err := New(context.TODO(), WithWriter(io.Discard), WithAsync(-1))
+	s.Require().Error(err)
+	s.Require().Equal("queue size must not be negative", err.Error())
+
+	_, err = New(context.TODO(), WithWriter(io.Discard, WithQueueSize(-1)), WithAsync(1))
+	s.Require().Error(err)
+	s.Require().Equal("writer #0: queue size must not be negative", err.Error())
+
+	_, err = New(context.TODO(), WithWriter(io.Discard), WithWriter(io.Discard, WithQueueSize(8)))
+	s.Require().Error(err)
+	s.Require().Equal("writer #1: queue size requires async mode", err.Error())
+
+	for _, policy := range []QueueFullPolicy{Block, Drop, Fail} {
+		_, err = New(context.TODO(), WithWriter(io.Discard), WithQueueFullPolicy(policy))
+		s.Require().Error(err)
+		s.Require().Equal("queue full policy requires async mode", err.Error())
+	}
+
+	mw, err := New(context.TODO(), WithWriter(io.Discard), WithAsync(1), WithQueueFullPolicy(Drop))
+	s.Require().NoError(err)
+	s.Require().NoError(mw.Close())
+}
+
+// gateWriter blocks writes until the gate is closed, started receives on
+// each write started
+type gateWriter struct {
+	gate    chan struct{}
+	started chan struct{}
+
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{
+		gate:    make(chan struct{}),
+		started: make(chan struct{}, 100),
+	}
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.gate
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buf.Write(p)
+}
+
+type orderedFile struct {
+	calls []string
+}
+
+func (f *orderedFile) Write(p []byte) (int, error) {
+	f.calls = append(f.calls, "write "+string(p))
+	return len(p), nil
+}
+
+func (f *orderedFile) Flush() error {
+	f.calls = append(f.calls, "flush")
+	return nil
+}
+
+func (f *orderedFile) Sync() error {
+	f.calls = append(f.calls, "sync")
+	return nil
+}
+
+func (f *orderedFile) Close() error {
+	f.calls = append(f.calls, "close")
+	return nil
+}
@@ -0,0 +1,69 @@
+package multiwriter
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	_ error = (*WriterError)(nil)
+	_ error = (*WriteError)(nil)
+)
+
+// WriterError is the failure of the single writer, Index is the position
+// of the writer in the order it was passed to the constructor. Op is one of
+// write, close, flush or sync.
+type WriterError struct {
+	Index      int
+	Op         string
+	BestEffort bool
+	Err        error
+}
+
+func (e *WriterError) Error() string {
+	switch e.Op {
+	case opClose:
+		return fmt.Sprintf("error closing channel #%d: %s", e.Index, e.Err)
+	case opFlush:
+		return fmt.Sprintf("error flushing channel #%d: %s", e.Index, e.Err)
+	case opSync:
+		return fmt.Sprintf("error syncing channel #%d: %s", e.Index, e.Err)
+	default:
+		return fmt.Sprintf("error writing to channel #%d: %s", e.Index, e.Err)
+	}
+}
+
+func (e *WriterError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError aggregates failures of all the writers of the single
+// operation, it's returned once any required writer failed
+type WriteError struct {
+	Errors []*WriterError
+}
+
+func (e *WriteError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d writer(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *WriteError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Indexes returns indexes of the failed writers
+func (e *WriteError) Indexes() []int {
+	idxs := make([]int, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		idxs = append(idxs, err.Index)
+	}
+	return idxs
+}
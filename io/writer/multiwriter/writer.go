@@ -4,52 +4,289 @@ import (
 	"context"
 	"io"
 	"runtime"
+	"slices"
+	"sync"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 )
 
+const (
+	opWrite = "write"
+	opClose = "close"
+	opFlush = "flush"
+	opSync  = "sync"
+)
+
+var (
+	_ MultiWriter = (*writer)(nil)
+
+	ErrClosed = errors.New("multiwriter is closed")
+)
+
+// MultiWriter fans out writes as well as Close, Flush and Sync calls to all
+// the writers. Close, Flush and Sync are called on the writers implementing
+// them only, the others are skipped.
+type MultiWriter interface {
+	io.WriteCloser
+	Flush() error
+	Sync() error
+}
+
+type Option func(mw *writer)
+
+// WithWriter adds the writer, writers are required by default: their failure
+// fails the whole write
+func WithWriter(w io.Writer, opts ...WriterOption) Option {
+	return func(mw *writer) {
+		s := &sink{
+			idx: len(mw.sinks),
+			w:   w,
+		}
+
+		for _, opt := range opts {
+			opt(s)
+		}
+
+		mw.sinks = append(mw.sinks, s)
+	}
+}
+
+// WithRemoveCallback sets the function called once the failed best-effort
+// writer is removed
+func WithRemoveCallback(fn func(idx int, err error)) Option {
+	return func(mw *writer) {
+		mw.onRemove = fn
+	}
+}
+
+type WriterOption func(s *sink)
+
+// BestEffort makes the writer failure not to fail the write, the writer is
+// removed from the subsequent writes instead
+func BestEffort() WriterOption {
+	return func(s *sink) {
+		s.bestEffort = true
+	}
+}
+
+// WithRetries sets the amount of times the failed write is retried with the
+// data not written yet before the writer is considered failed
+func WithRetries(n int) WriterOption {
+	return func(s *sink) {
+		s.retries = n
+	}
+}
+
+type sink struct {
+	idx        int
+	w          io.Writer
+	bestEffort bool
+	retries    int
+	queueSize  int
+}
+
+func (s *sink) write(p []byte) error {
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		var n int
+		n, err = s.w.Write(p)
+		if err == nil && n == len(p) {
+			return nil
+		}
+
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return err
+}
+
+type flusher interface {
+	Flush() error
+}
+
+type syncer interface {
+	Sync() error
+}
+
 type writer struct {
-	ctx     context.Context
-	writers []io.Writer
+	ctx         context.Context
+	onRemove    func(idx int, err error)
+	queueSize   int
+	queuePolicy QueueFullPolicy
+	// queuePolicySet tells whether WithQueueFullPolicy is used to reject
+	// it without async mode
+	queuePolicySet bool
+
+	mutex  sync.Mutex
+	all    []*sink
+	sinks  []*sink
+	closed bool
 }
 
 func (mw *writer) Write(p []byte) (n int, err error) {
+	if err := mw.each(opWrite, func(s *sink) error {
+		return s.write(p)
+	}); err != nil {
+		return len(p), err
+	}
+	return len(p), nil
+}
+
+// Close closes all the writers implementing io.Closer including removed
+// ones, the multiwriter couldn't be used afterwards
+func (mw *writer) Close() error {
+	return mw.each(opClose, func(s *sink) error {
+		if c, ok := s.w.(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	})
+}
+
+func (mw *writer) Flush() error {
+	return mw.each(opFlush, func(s *sink) error {
+		if f, ok := s.w.(flusher); ok {
+			return f.Flush()
+		}
+		return nil
+	})
+}
+
+func (mw *writer) Sync() error {
+	return mw.each(opSync, func(s *sink) error {
+		if sc, ok := s.w.(syncer); ok {
+			return sc.Sync()
+		}
+		return nil
+	})
+}
+
+// each runs fn for each writer concurrently applying writer policies to
+// the failures
+func (mw *writer) each(op string, fn func(s *sink) error) error {
+	mw.mutex.Lock()
+	if mw.closed {
+		mw.mutex.Unlock()
+		return ErrClosed
+	}
+
+	sinks := slices.Clone(mw.sinks)
+	if op == opClose {
+		sinks = slices.Clone(mw.all)
+		mw.closed = true
+	}
+	mw.mutex.Unlock()
+
+	errs := make([]error, len(sinks))
+
 	g, _ := errgroup.WithContext(mw.ctx)
 	g.SetLimit(runtime.NumCPU())
 
-	for idx, w := range mw.writers {
-		g.Go(func(idx int, w io.Writer) func() error {
-			return func() error {
-				n, err := w.Write(p)
-				if err != nil {
-					return errors.Wrapf(err, "error writing to channel #%d", idx)
-				}
+	for i, s := range sinks {
+		g.Go(func() error {
+			errs[i] = fn(s)
+			return nil
+		})
+	}
+
+	// errors are collected per writer so there's nothing returned here
+	_ = g.Wait()
+
+	werr := &WriteError{}
+	failed := false
+	for i, s := range sinks {
+		if errs[i] == nil {
+			continue
+		}
+
+		werr.Errors = append(werr.Errors, &WriterError{
+			Index:      s.idx,
+			Op:         op,
+			BestEffort: s.bestEffort,
+			Err:        errs[i],
+		})
 
-				if n != len(p) {
-					return errors.Wrapf(io.ErrShortWrite, "error writing to channel #%d", idx)
-				}
-				return nil
-			}
-		}(idx, w))
+		if !s.bestEffort {
+			failed = true
+			continue
+		}
+
+		if op != opClose {
+			mw.remove(s, errs[i])
+		}
 	}
 
-	return len(p), g.Wait()
+	if failed {
+		return werr
+	}
+	return nil
 }
 
-func NewMultiWriter(ctx context.Context, writers ...io.Writer) (io.Writer, error) {
-	w := make([]io.Writer, len(writers))
+func (mw *writer) remove(s *sink, err error) {
+	mw.mutex.Lock()
+	mw.sinks = slices.DeleteFunc(mw.sinks, func(v *sink) bool {
+		return v == s
+	})
+	mw.mutex.Unlock()
+
+	if mw.onRemove != nil {
+		mw.onRemove(s.idx, err)
+	}
+}
+
+// New creates multiwriter writing to all the writers concurrently
+func New(ctx context.Context, opts ...Option) (MultiWriter, error) {
+	mw := &writer{
+		ctx: ctx,
+	}
+
+	for _, opt := range opts {
+		opt(mw)
+	}
+
+	mw.all = slices.Clone(mw.sinks)
+
+	for _, s := range mw.sinks {
+		if s.w == nil {
+			return nil, errors.Errorf("writer #%d is nil", s.idx)
+		}
+
+		if s.retries < 0 {
+			return nil, errors.Errorf("writer #%d: retries amount must not be negative", s.idx)
+		}
+
+		if s.queueSize < 0 {
+			return nil, errors.Errorf("writer #%d: queue size must not be negative", s.idx)
+		}
+
+		if s.queueSize > 0 && mw.queueSize == 0 {
+			return nil, errors.Errorf("writer #%d: queue size requires async mode", s.idx)
+		}
+	}
+
+	if mw.queueSize < 0 {
+		return nil, errors.New("queue size must not be negative")
+	}
+
+	if mw.queuePolicySet && mw.queueSize == 0 {
+		return nil, errors.New("queue full policy requires async mode")
+	}
+
+	if mw.queueSize > 0 {
+		return newAsyncWriter(mw), nil
+	}
+	return mw, nil
+}
 
-	n := copy(w, writers)
-	if n != len(writers) {
-		return nil, errors.Errorf(
-			"unexpected copy amount: expected %d copied %d. Looks like internal error or memory corruption",
-			len(writers), n,
-		)
+// NewMultiWriter creates multiwriter with all the writers required
+func NewMultiWriter(ctx context.Context, writers ...io.Writer) (MultiWriter, error) {
+	opts := make([]Option, 0, len(writers))
+	for _, w := range writers {
+		opts = append(opts, WithWriter(w))
 	}
 
-	return &writer{
-		ctx:     ctx,
-		writers: w,
-	}, nil
+	return New(ctx, opts...)
 }
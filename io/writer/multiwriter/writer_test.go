@@ -2,11 +2,14 @@ package multiwriter
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"testing"
 
+	"github.com/pkg/errors"
+
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -49,6 +52,237 @@ func (s *multiWriterTestSuite) TestWrite() {
 	}
 }
 
+func (s *multiWriterTestSuite) TestBestEffort() {
+	required := &bytes.Buffer{}
+	healthy := &bytes.Buffer{}
+	broken := &failingWriter{failures: -1}
+
+	type removed struct {
+		idx int
+		err error
+	}
+	removedCh := make(chan removed, 1)
+
+	w, err := New(context.TODO(),
+		WithWriter(required),
+		WithWriter(broken, BestEffort()),
+		WithWriter(healthy, BestEffort()),
+		WithRemoveCallback(func(idx int, err error) {
+			removedCh <- removed{idx: idx, err: err}
+		}),
+	)
+	s.Require().NoError(err)
+
+	n, err := w.Write([]byte("first"))
+	s.Require().NoError(err)
+	s.Require().Equal(5, n)
+
+	r := <-removedCh
+	s.Require().Equal(1, r.idx)
+	s.Require().ErrorIs(r.err, errWriteFailed)
+
+	n, err = w.Write([]byte("second"))
+	s.Require().NoError(err)
+	s.Require().Equal(6, n)
+
+	s.Require().Equal("firstsecond", required.String())
+	s.Require().Equal("firstsecond", healthy.String())
+	s.Require().Equal(1, broken.calls)
+	s.Require().Empty(removedCh)
+}
+
+func (s *multiWriterTestSuite) TestRequiredFailure() {
+	healthy := &bytes.Buffer{}
+	broken := &failingWriter{failures: 1}
+	bestEffort := &failingWriter{failures: -1}
+
+	w, err := New(context.TODO(),
+		WithWriter(healthy),
+		WithWriter(broken),
+		WithWriter(bestEffort, BestEffort()),
+	)
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("first"))
+	s.Require().Error(err)
+	s.Require().ErrorIs(err, errWriteFailed)
+	s.Require().Equal("2 writer(s) failed: error writing to channel #1: write failed; error writing to channel #2: write failed", err.Error())
+
+	werr := &WriteError{}
+	s.Require().ErrorAs(err, &werr)
+	s.Require().Equal([]int{1, 2}, werr.Indexes())
+	s.Require().False(werr.Errors[0].BestEffort)
+	s.Require().True(werr.Errors[1].BestEffort)
+
+	// required writer is kept and retried on the next write
+	_, err = w.Write([]byte("second"))
+	s.Require().NoError(err)
+	s.Require().Equal("second", broken.buf.String())
+	s.Require().Equal("firstsecond", healthy.String())
+	s.Require().Equal(1, bestEffort.calls)
+}
+
+func (s *multiWriterTestSuite) TestRetries() {
+	flaky := &failingWriter{failures: 2, short: true}
+
+	w, err := New(context.TODO(), WithWriter(flaky, WithRetries(2)))
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("abcdef"))
+	s.Require().NoError(err)
+	s.Require().Equal("abcdef", flaky.buf.String())
+	s.Require().Equal(3, flaky.calls)
+
+	flaky = &failingWriter{failures: 2}
+
+	w, err = New(context.TODO(), WithWriter(flaky, WithRetries(1)))
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("abcdef"))
+	s.Require().ErrorIs(err, errWriteFailed)
+	s.Require().Equal(2, flaky.calls)
+}
+
+func (s *multiWriterTestSuite) TestShortWrite() {
+	w, err := NewMultiWriter(context.TODO(), &shortWriter{})
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("abcdef"))
+	s.Require().ErrorIs(err, io.ErrShortWrite)
+}
+
+func (s *multiWriterTestSuite) TestNewValidation() {
+	_, err := New(context.TODO(), WithWriter(nil))
+	s.Require().Error(err)
+	s.Require().Equal("writer #0 is nil", err.Error())
+
+	_, err = New(context.TODO(), WithWriter(&bytes.Buffer{}), WithWriter(&bytes.Buffer{}, WithRetries(-1)))
+	s.Require().Error(err)
+	s.Require().Equal("writer #1: retries amount must not be negative", err.Error())
+}
+
+func (s *multiWriterTestSuite) TestCloseFlushSync() {
+	plain := &bytes.Buffer{}
+	file := &fakeFile{}
+	buffered := &fakeFile{}
+	gz := gzip.NewWriter(buffered)
+
+	w, err := NewMultiWriter(context.TODO(), plain, file, gz)
+	s.Require().NoError(err)
+
+	_, err = w.Write([]byte("data"))
+	s.Require().NoError(err)
+
+	s.Require().NoError(w.Flush())
+	s.Require().NotZero(buffered.buf.Len())
+
+	s.Require().NoError(w.Sync())
+	s.Require().Equal(1, file.syncs)
+
+	s.Require().NoError(w.Close())
+	s.Require().True(file.closed)
+
+	// gzip writer closes the stream but not the underlying writer
+	s.Require().False(buffered.closed)
+	zr, err := gzip.NewReader(&buffered.buf)
+	s.Require().NoError(err)
+	data, err := io.ReadAll(zr)
+	s.Require().NoError(err)
+	s.Require().Equal("data", string(data))
+
+	_, err = w.Write([]byte("data"))
+	s.Require().ErrorIs(err, ErrClosed)
+	s.Require().ErrorIs(w.Flush(), ErrClosed)
+	s.Require().ErrorIs(w.Sync(), ErrClosed)
+	s.Require().ErrorIs(w.Close(), ErrClosed)
+}
+
+func (s *multiWriterTestSuite) TestCloseErrors() {
+	required := &fakeFile{closeErr: errors.New("close failed")}
+	bestEffort := &fakeFile{syncErr: errors.New("sync failed"), closeErr: errors.New("close failed")}
+	healthy := &fakeFile{}
+
+	removed := []int{}
+	w, err := New(context.TODO(),
+		WithWriter(required),
+		WithWriter(bestEffort, BestEffort()),
+		WithWriter(healthy),
+		WithRemoveCallback(func(idx int, err error) {
+			removed = append(removed, idx)
+		}),
+	)
+	s.Require().NoError(err)
+
+	// best-effort writer is removed on sync failure
+	s.Require().NoError(w.Sync())
+	s.Require().Equal([]int{1}, removed)
+	s.Require().Equal(1, healthy.syncs)
+
+	err = w.Close()
+	s.Require().Error(err)
+	s.Require().Equal("2 writer(s) failed: error closing channel #0: close failed; error closing channel #1: close failed", err.Error())
+
+	// removed writers are closed as well
+	s.Require().True(required.closed)
+	s.Require().True(bestEffort.closed)
+	s.Require().True(healthy.closed)
+}
+
+var errWriteFailed = errors.New("write failed")
+
+type fakeFile struct {
+	buf      bytes.Buffer
+	syncs    int
+	closed   bool
+	syncErr  error
+	closeErr error
+}
+
+func (f *fakeFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *fakeFile) Sync() error {
+	f.syncs++
+	return f.syncErr
+}
+
+func (f *fakeFile) Close() error {
+	f.closed = true
+	return f.closeErr
+}
+
+// failingWriter fails the first failures writes, negative value means
+// every write fails. Short failures write a half of the data without error.
+type failingWriter struct {
+	failures int
+	short    bool
+	calls    int
+	buf      bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.failures == 0 {
+		return w.buf.Write(p)
+	}
+
+	if w.failures > 0 {
+		w.failures--
+	}
+
+	if w.short {
+		return w.buf.Write(p[:len(p)/2])
+	}
+	return 0, errWriteFailed
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
 // ========================================================================
 // Test suite setup
 // ========================================================================